@@ -8,11 +8,13 @@ NC='\033[0m' # No Color
 
 # Help information
 usage() {
-    echo "Usage: $0 -c <cluster_name> -n <namespace> [-s] [-f]"
+    echo "Usage: $0 -c <cluster_name> -n <namespace> [-s] [-f] [-p] [-r]"
     echo "  -c: Cluster name"
     echo "  -n: Namespace"
     echo "  -s: Create new cluster (optional, default: use existing cluster)"
     echo "  -f: Force creation (optional, will delete existing resources if they exist)"
+    echo "  -p: Enable EnvPatchPolicy custom resources (optional, deploys the CRD and its RBAC)"
+    echo "  -r: Check that referenced ConfigMaps and Secrets exist (optional, grants cluster-wide read access to them)"
     exit 1
 }
 
@@ -48,7 +50,9 @@ check_namespace_exists() {
 
 # Parameter processing
 force_creation=false
-while getopts "c:n:shf" opt; do
+enable_policies=false
+check_references=false
+while getopts "c:n:shfpr" opt; do
     case ${opt} in
         c )
             cluster_name=$OPTARG
@@ -62,6 +66,12 @@ while getopts "c:n:shf" opt; do
         f )
             force_creation=true
             ;;
+        p )
+            enable_policies=true
+            ;;
+        r )
+            check_references=true
+            ;;
         h )
             usage
             ;;
@@ -214,11 +224,36 @@ if ! cat mutatingwebhook.yaml | ./webhook-patch-ca-bundle.sh > mutatingwebhook-c
 fi
 success "Certificates and configuration generated successfully 🔒"
 
+# Optional features add webhook arguments and the resources they need
+webhook_args=()
+resources=(rbac.yaml)
+if [ "$enable_policies" = true ]; then
+    webhook_args+=(-enablePolicies)
+    resources+=(crd-envpatchpolicy.yaml rbac-policies.yaml)
+fi
+if [ "$check_references" = true ]; then
+    warn "-r lets the webhook service account read all ConfigMaps and Secrets in the cluster"
+    webhook_args+=(-checkReferences)
+    resources+=(rbac-references.yaml)
+fi
+resources+=(configmap.yaml deployment.yaml service.yaml mutatingwebhook-ca-bundle.yaml)
+
+# Render a manifest: substitute ${NAMESPACE} and prepend the optional webhook arguments
+render() {
+    if [ "$1" = deployment.yaml ] && [ ${#webhook_args[@]} -gt 0 ]; then
+        kubectl create -f "$1" --dry-run=client -o json |
+            jq '.spec.template.spec.containers[0].args = $ARGS.positional + .spec.template.spec.containers[0].args' \
+                --args -- "${webhook_args[@]}"
+    else
+        sed -e "s|\${NAMESPACE}|${namespace}|g" "$1"
+    fi
+}
+
 # Deploy resources
 info "Deploying resources... 🚀"
-for resource in configmap.yaml deployment.yaml service.yaml mutatingwebhook-ca-bundle.yaml; do
+for resource in "${resources[@]}"; do
     info "Deploying $resource..."
-    if ! kubectl create -f "$resource" -n "$namespace"; then
+    if ! render "$resource" | kubectl create -f - -n "$namespace"; then
         error "Failed to deploy $resource ❌"
     fi
 done
@@ -51,7 +51,7 @@ func addRequiredNodeAffinityTerms(target, requiredNodeAffinityTerms []corev1.Nod
 			}
 			if !optExists {
 				op = "add"
-				path = path + "/-"
+				path = jsonPointer(path, "-")
 			}
 		}
 		if !skip {
@@ -60,8 +60,6 @@ func addRequiredNodeAffinityTerms(target, requiredNodeAffinityTerms []corev1.Nod
 				Path:  path,
 				Value: value,
 			})
-		} else {
-			patch = []patchOperation{}
 		}
 	}
 	return patch
@@ -116,7 +114,7 @@ func addPreferredNodeAffinityTerms(target, preferredNodeAffinityTerms []corev1.P
 			}
 			if !optExists {
 				op = "add"
-				path = path + "/-"
+				path = jsonPointer(path, "-")
 			}
 		}
 		if !skip {
@@ -125,8 +123,6 @@ func addPreferredNodeAffinityTerms(target, preferredNodeAffinityTerms []corev1.P
 				Path:  path,
 				Value: value,
 			})
-		} else {
-			patch = []patchOperation{}
 		}
 	}
 	return patch
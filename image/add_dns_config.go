@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DNSListPosition places the injected values of a DNS list relative to those of the pod
+type DNSListPosition string
+
+const (
+	DNSListPositionAppend  DNSListPosition = "append"
+	DNSListPositionPrepend DNSListPosition = "prepend"
+)
+
+// Kubernetes limits of the pod DNS config, see ValidatePodDNSConfig
+const (
+	maxDNSNameservers     = 3
+	maxDNSSearchPaths     = 32
+	maxDNSSearchListChars = 2048
+)
+
+// DNSList is a list of DNS nameservers or search domains to inject. Values the pod already
+// lists keep their position.
+type DNSList struct {
+	Values []string `yaml:"values" json:"values"`
+	// Position defaults to append, prepend gives the injected values precedence
+	Position DNSListPosition `yaml:"position,omitempty" json:"position,omitempty"`
+}
+
+// mergeDNSList returns the values of the pod merged with the injected values
+func mergeDNSList(target []string, list *DNSList) []string {
+	var missing []string
+	for _, value := range list.Values {
+		if !containsString(target, value) && !containsString(missing, value) {
+			missing = append(missing, value)
+		}
+	}
+	if len(missing) == 0 {
+		return target
+	}
+	if list.Position == DNSListPositionPrepend {
+		return append(missing, target...)
+	}
+	return append(append([]string{}, target...), missing...)
+}
+
+// addDNSList performs the mutation needed to merge a DNS list into the target resource. A merged
+// list above the Kubernetes limits would be rejected with the pod, so it is skipped with an
+// admission warning.
+func addDNSList(mc *mutationContext, target []string, list *DNSList, basePath string, validate func([]string) string) (patch []patchOperation) {
+	merged := mergeDNSList(target, list)
+	if len(merged) == len(target) {
+		structuredLog(LogLevelDebug, "DNSConfig", "All values already present at %s", basePath)
+		return patch
+	}
+	if reason := validate(merged); reason != "" {
+		mc.warn("%s: %s, the values %v were not injected", basePath, reason, list.Values)
+		return patch
+	}
+
+	op := "replace"
+	if len(target) == 0 {
+		op = "add"
+	}
+	structuredLog(LogLevelInfo, "DNSConfig", "Setting %s to %v", basePath, merged)
+	return append(patch, patchOperation{Op: op, Path: basePath, Value: merged})
+}
+
+// dnsNameserversLimit returns why the nameservers exceed the Kubernetes limits, if they do
+func dnsNameserversLimit(nameservers []string) string {
+	if len(nameservers) > maxDNSNameservers {
+		return "must not have more than 3 nameservers"
+	}
+	return ""
+}
+
+// dnsSearchesLimit returns why the search domains exceed the Kubernetes limits, if they do
+func dnsSearchesLimit(searches []string) string {
+	if len(searches) > maxDNSSearchPaths {
+		return "must not have more than 32 search paths"
+	}
+	if len(strings.Join(searches, " ")) > maxDNSSearchListChars {
+		return "must not have more than 2048 characters (including spaces) in the search list"
+	}
+	return ""
+}
+
+// addDNSPolicy performs the mutation needed to set the DNS policy of the pod
+func addDNSPolicy(spec *corev1.PodSpec, policy *SchedulingField) []patchOperation {
+	current := string(spec.DNSPolicy)
+	if spec.DNSPolicy == corev1.DNSClusterFirst {
+		// set by API defaulting before admission, so the pod did not choose it
+		current = ""
+	}
+	return setSchedulingField(current, policy, "/spec/dnsPolicy")
+}
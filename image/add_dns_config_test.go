@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestAddDNSConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		pod         string
+		config      string
+		nameservers []string
+		searches    []string
+		policy      corev1.DNSPolicy
+		warns       int
+	}{
+		{
+			name: "pod without dns config",
+			pod: `
+spec:
+  dnsPolicy: ClusterFirst
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+dnsNameservers:
+  values: [10.0.0.10]
+dnsSearches:
+  values: [corp.example.com]
+dnsPolicy:
+  value: None
+`,
+			nameservers: []string{"10.0.0.10"},
+			searches:    []string{"corp.example.com"},
+			policy:      corev1.DNSNone,
+		},
+		{
+			name: "append and prepend",
+			pod: `
+spec:
+  dnsConfig:
+    nameservers: [10.0.0.53]
+    searches: [shop.svc.cluster.local, corp.example.com]
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+dnsNameservers:
+  values: [10.0.0.10, 10.0.0.53]
+  position: prepend
+dnsSearches:
+  values: [corp.example.com, example.com]
+`,
+			nameservers: []string{"10.0.0.10", "10.0.0.53"},
+			searches:    []string{"shop.svc.cluster.local", "corp.example.com", "example.com"},
+		},
+		{
+			name: "too many nameservers",
+			pod: `
+spec:
+  dnsConfig:
+    nameservers: [10.0.0.1, 10.0.0.2]
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+dnsNameservers:
+  values: [10.0.0.10, 10.0.0.11]
+`,
+			nameservers: []string{"10.0.0.1", "10.0.0.2"},
+			warns:       1,
+		},
+		{
+			name: "dnsPolicy set by the pod",
+			pod: `
+spec:
+  dnsPolicy: Default
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+dnsPolicy:
+  value: ClusterFirstWithHostNet
+`,
+			policy: corev1.DNSDefault,
+		},
+		{
+			name: "forced dnsPolicy",
+			pod: `
+spec:
+  dnsPolicy: Default
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+dnsPolicy:
+  value: ClusterFirstWithHostNet
+  force: true
+`,
+			policy: corev1.DNSClusterFirstWithHostNet,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, mc := mustMutateTestPod(t, testPod(t, tt.pod), testConfig(t, tt.config))
+			var nameservers, searches []string
+			if dns := patched.Spec.DNSConfig; dns != nil {
+				nameservers, searches = dns.Nameservers, dns.Searches
+			}
+			if !equalStrings(nameservers, tt.nameservers) {
+				t.Errorf("got nameservers %v, want %v", nameservers, tt.nameservers)
+			}
+			if !equalStrings(searches, tt.searches) {
+				t.Errorf("got searches %v, want %v", searches, tt.searches)
+			}
+			if tt.policy != "" && patched.Spec.DNSPolicy != tt.policy {
+				t.Errorf("got dnsPolicy %s, want %s", patched.Spec.DNSPolicy, tt.policy)
+			}
+			if len(mc.warnings) != tt.warns {
+				t.Errorf("got warnings %q, want %d", mc.warnings, tt.warns)
+			}
+		})
+	}
+}
@@ -5,9 +5,16 @@ import (
 	corev1 "k8s.io/api/core/v1"
 )
 
+// DNSOption is a DNS resolver option to inject
+type DNSOption struct {
+	corev1.PodDNSConfigOption `yaml:",inline"`
+
+	MergeStrategy MergeStrategy `yaml:"mergeStrategy,omitempty" json:"mergeStrategy,omitempty"`
+}
+
 // addDnsOptions performs the mutation(s) needed to add the extra dnsOptions to the target
 // resource
-func addDnsOptions(target, dnsOptions []corev1.PodDNSConfigOption, basePath string) (patch []patchOperation) {
+func addDnsOptions(target []corev1.PodDNSConfigOption, dnsOptions []DNSOption, basePath string, strategy MergeStrategy) (patch []patchOperation, err error) {
 	first := len(target) == 0
 	if first {
 		structuredLog(LogLevelDebug, "DNSOptions", "No existing DNS options found, will create new array")
@@ -17,14 +24,14 @@ func addDnsOptions(target, dnsOptions []corev1.PodDNSConfigOption, basePath stri
 
 	var value interface{}
 	for _, dnsOpt := range dnsOptions {
-		value = dnsOpt
+		value = dnsOpt.PodDNSConfigOption
 		path := basePath
 		var skip bool
 		var op string
 		if first {
 			first = false
 			op = "add"
-			value = []corev1.PodDNSConfigOption{dnsOpt}
+			value = []corev1.PodDNSConfigOption{dnsOpt.PodDNSConfigOption}
 			structuredLog(LogLevelDebug, "DNSOptions", "Adding first DNS option with name: %s", dnsOpt.Name)
 		} else {
 			optExists := false
@@ -34,17 +41,20 @@ func addDnsOptions(target, dnsOptions []corev1.PodDNSConfigOption, basePath stri
 					optExists = true
 					valueEqual := cmp.Equal(targetOpt.Value, dnsOpt.Value)
 
-					skip, op, path = checkReplaceOrSkip(idx, path, valueEqual)
+					skip, op, path, err = checkMergeStrategy(dnsOpt.MergeStrategy.or(strategy), idx, path, dnsOpt.Name, valueEqual)
+					if err != nil {
+						return nil, err
+					}
 					if !skip {
 						structuredLog(LogLevelInfo, "DNSOptions", "Updating existing DNS option at index %d with name: %s", idx, dnsOpt.Name)
 					} else {
-						structuredLog(LogLevelDebug, "DNSOptions", "Skipping DNS option update at index %d with name: %s (no changes needed)", idx, dnsOpt.Name)
+						structuredLog(LogLevelDebug, "DNSOptions", "Skipping DNS option update at index %d with name: %s (no changes needed or keeping existing value)", idx, dnsOpt.Name)
 					}
 				}
 			}
 			if !optExists {
 				op = "add"
-				path = path + "/-"
+				path = jsonPointer(path, "-")
 				structuredLog(LogLevelInfo, "DNSOptions", "Adding new DNS option with name: %s", dnsOpt.Name)
 			}
 		}
@@ -54,9 +64,7 @@ func addDnsOptions(target, dnsOptions []corev1.PodDNSConfigOption, basePath stri
 				Path:  path,
 				Value: value,
 			})
-		} else {
-			patch = []patchOperation{}
 		}
 	}
-	return patch
+	return patch, nil
 }
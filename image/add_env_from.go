@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// EnvFromSource is a ConfigMap or Secret whose keys are injected as environment variables
+type EnvFromSource struct {
+	corev1.EnvFromSource `yaml:",inline"`
+	ContainerTarget      `yaml:",inline"`
+
+	// RequireExists skips the source with an admission warning when the referenced object does
+	// not exist in the namespace of the pod. It requires the webhook to run with -checkReferences.
+	RequireExists bool `yaml:"requireExists,omitempty" json:"requireExists,omitempty"`
+	// MergeStrategy overrides the envFrom block merge strategy for this source
+	MergeStrategy MergeStrategy `yaml:"mergeStrategy,omitempty" json:"mergeStrategy,omitempty"`
+}
+
+// envFromRef returns the kind and name of the referenced object
+func envFromRef(source corev1.EnvFromSource) (kind, name string) {
+	switch {
+	case source.ConfigMapRef != nil:
+		return "ConfigMap", source.ConfigMapRef.Name
+	case source.SecretRef != nil:
+		return "Secret", source.SecretRef.Name
+	}
+	return "", ""
+}
+
+// envFromKey identifies a source by its referenced object and prefix
+func envFromKey(source corev1.EnvFromSource) string {
+	kind, name := envFromRef(source)
+	return kind + "/" + name + "/" + source.Prefix
+}
+
+// addEnvFrom performs the mutation(s) needed to add the extra envFrom sources to the target
+// resource
+func addEnvFrom(target []corev1.EnvFromSource, sources []EnvFromSource, basePath string, strategy MergeStrategy) (patch []patchOperation, err error) {
+	first := len(target) == 0
+	if first {
+		structuredLog(LogLevelDebug, "EnvFrom", "No existing envFrom sources found, will create new array")
+	} else {
+		structuredLog(LogLevelDebug, "EnvFrom", "Found %d existing envFrom sources", len(target))
+	}
+
+	var value interface{}
+	for _, source := range sources {
+		value = source.EnvFromSource
+		key := envFromKey(source.EnvFromSource)
+		path := basePath
+		var skip bool
+		var op string
+		if first {
+			first = false
+			op = "add"
+			value = []corev1.EnvFromSource{source.EnvFromSource}
+			structuredLog(LogLevelDebug, "EnvFrom", "Adding first envFrom source: %s", key)
+		} else {
+			sourceExists := false
+			for idx, targetSource := range target {
+				if envFromKey(targetSource) == key {
+					sourceExists = true
+					configMapEqual := cmp.Equal(targetSource.ConfigMapRef, source.ConfigMapRef)
+					secretEqual := cmp.Equal(targetSource.SecretRef, source.SecretRef)
+
+					skip, op, path, err = checkMergeStrategy(source.MergeStrategy.or(strategy), idx, path, key, configMapEqual, secretEqual)
+					if err != nil {
+						return nil, err
+					}
+					if !skip {
+						structuredLog(LogLevelInfo, "EnvFrom", "Updating existing envFrom source at index %d: %s", idx, key)
+					} else {
+						structuredLog(LogLevelDebug, "EnvFrom", "Skipping envFrom source update at index %d: %s (no changes needed or keeping existing value)", idx, key)
+					}
+				}
+			}
+			if !sourceExists {
+				op = "add"
+				path = jsonPointer(path, "-")
+				structuredLog(LogLevelInfo, "EnvFrom", "Adding new envFrom source: %s", key)
+			}
+		}
+		if !skip {
+			patch = append(patch, patchOperation{
+				Op:    op,
+				Path:  path,
+				Value: value,
+			})
+		}
+	}
+	return patch, nil
+}
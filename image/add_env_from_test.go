@@ -0,0 +1,182 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	metadatafake "k8s.io/client-go/metadata/fake"
+)
+
+// newTestObjectCache returns a synced cache of the metadata of the given ConfigMaps and
+// Secrets, written as "Kind/namespace/name"
+func newTestObjectCache(t *testing.T, objects ...string) *objectCache {
+	t.Helper()
+	scheme := metadatafake.NewTestScheme()
+	if err := metav1.AddMetaToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	var objs []runtime.Object
+	for _, object := range objects {
+		parts := strings.Split(object, "/")
+		objs = append(objs, &metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: parts[0]},
+			ObjectMeta: metav1.ObjectMeta{Namespace: parts[1], Name: parts[2]},
+		})
+	}
+	oc := newObjectCache(metadatafake.NewSimpleMetadataClient(scheme, objs...), 0, configMapGVR, secretGVR)
+
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	if err := oc.run(stop); err != nil {
+		t.Fatal(err)
+	}
+	return oc
+}
+
+// envFromKeys returns the envFrom sources of a container as "Kind/name/prefix"
+func envFromKeys(container corev1.Container) []string {
+	keys := make([]string, 0, len(container.EnvFrom))
+	for _, source := range container.EnvFrom {
+		keys = append(keys, envFromKey(source))
+	}
+	return keys
+}
+
+func TestAddEnvFrom(t *testing.T) {
+	pod := testPod(t, `
+metadata:
+  namespace: shop
+spec:
+  containers:
+    - name: app
+      image: nginx
+      envFrom:
+        - configMapRef:
+            name: app-config
+    - name: istio-proxy
+      image: istio/proxyv2
+`)
+
+	tests := []struct {
+		name    string
+		config  string
+		objects []string // existing objects, enables reference checks
+		want    map[string][]string
+		warns   int
+	}{
+		{
+			name: "configmap and secret",
+			config: `
+envFrom:
+  - configMapRef:
+      name: app-config
+  - configMapRef:
+      name: app-config
+    prefix: APP_
+  - secretRef:
+      name: db-credentials
+`,
+			want: map[string][]string{
+				"app":         {"ConfigMap/app-config/", "ConfigMap/app-config/APP_", "Secret/db-credentials/"},
+				"istio-proxy": {"ConfigMap/app-config/", "ConfigMap/app-config/APP_", "Secret/db-credentials/"},
+			},
+		},
+		{
+			name: "container selector",
+			config: `
+envFrom:
+  - secretRef:
+      name: db-credentials
+    containerSelector:
+      excludeNames: [istio-proxy]
+`,
+			want: map[string][]string{
+				"app":         {"ConfigMap/app-config/", "Secret/db-credentials/"},
+				"istio-proxy": {},
+			},
+		},
+		{
+			name: "requireExists without reference checks",
+			config: `
+envFrom:
+  - secretRef:
+      name: missing
+    requireExists: true
+`,
+			want: map[string][]string{
+				"app":         {"ConfigMap/app-config/", "Secret/missing/"},
+				"istio-proxy": {"Secret/missing/"},
+			},
+		},
+		{
+			name: "requireExists with reference checks",
+			config: `
+envFrom:
+  - secretRef:
+      name: db-credentials
+    requireExists: true
+  - secretRef:
+      name: missing
+    requireExists: true
+  - configMapRef:
+      name: in-other-namespace
+    requireExists: true
+`,
+			objects: []string{"Secret/shop/db-credentials", "ConfigMap/billing/in-other-namespace"},
+			want: map[string][]string{
+				"app":         {"ConfigMap/app-config/", "Secret/db-credentials/"},
+				"istio-proxy": {"Secret/db-credentials/"},
+			},
+			warns: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := &mutationContext{}
+			if tt.objects != nil {
+				mc.objects = newTestObjectCache(t, tt.objects...)
+			}
+			patched, err := mutateTestPodContext(mc, pod, testConfig(t, tt.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, container := range patched.Spec.Containers {
+				if got := envFromKeys(container); !equalStrings(got, tt.want[container.Name]) {
+					t.Errorf("container %s: got envFrom %v, want %v", container.Name, got, tt.want[container.Name])
+				}
+			}
+			if len(mc.warnings) != tt.warns {
+				t.Errorf("got warnings %q, want %d", mc.warnings, tt.warns)
+			}
+		})
+	}
+}
+
+func TestUncheckedReferences(t *testing.T) {
+	envConfig, errs := parseConfig([]byte(`
+profiles:
+  - name: proxy
+    envFrom:
+      - configMapRef:
+          name: proxy
+      - secretRef:
+          name: proxy-credentials
+        requireExists: true
+    imagePullSecrets:
+      - name: registry
+        requireExists: true
+`))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	got := uncheckedReferences(&envConfig.Profiles[0].Config, field.NewPath("profiles").Index(0))
+	if want := []string{"profiles[0].envFrom[1].requireExists", "profiles[0].imagePullSecrets[0].requireExists"}; !equalStrings(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -7,7 +7,7 @@ import (
 
 // addEnv performs the mutation(s) needed to add the extra environment variables to the target
 // resource
-func addEnv(target, envVars []corev1.EnvVar, basePath string) (patch []patchOperation) {
+func addEnv(target []corev1.EnvVar, envVars []EnvVar, basePath string, strategy MergeStrategy) (patch []patchOperation, err error) {
 	first := len(target) == 0
 	if first {
 		structuredLog(LogLevelDebug, "EnvVars", "No existing environment variables found, will create new array")
@@ -17,14 +17,14 @@ func addEnv(target, envVars []corev1.EnvVar, basePath string) (patch []patchOper
 
 	var value interface{}
 	for _, envVar := range envVars {
-		value = envVar
+		value = envVar.EnvVar
 		path := basePath
 		var skip bool
 		var op string
 		if first {
 			first = false
 			op = "add"
-			value = []corev1.EnvVar{envVar}
+			value = []corev1.EnvVar{envVar.EnvVar}
 			structuredLog(LogLevelDebug, "EnvVars", "Adding first environment variable: %s", envVar.Name)
 		} else {
 			optExists := false
@@ -35,17 +35,20 @@ func addEnv(target, envVars []corev1.EnvVar, basePath string) (patch []patchOper
 					valueEqual := cmp.Equal(targetOpt.Value, envVar.Value)
 					valueFromEqual := cmp.Equal(targetOpt.ValueFrom, envVar.ValueFrom)
 
-					skip, op, path = checkReplaceOrSkip(idx, path, valueEqual, valueFromEqual)
+					skip, op, path, err = checkMergeStrategy(envVar.MergeStrategy.or(strategy), idx, path, envVar.Name, valueEqual, valueFromEqual)
+					if err != nil {
+						return nil, err
+					}
 					if !skip {
 						structuredLog(LogLevelInfo, "EnvVars", "Updating existing environment variable at index %d: %s", idx, envVar.Name)
 					} else {
-						structuredLog(LogLevelDebug, "EnvVars", "Skipping environment variable update at index %d: %s (no changes needed)", idx, envVar.Name)
+						structuredLog(LogLevelDebug, "EnvVars", "Skipping environment variable update at index %d: %s (no changes needed or keeping existing value)", idx, envVar.Name)
 					}
 				}
 			}
 			if !optExists {
 				op = "add"
-				path = path + "/-"
+				path = jsonPointer(path, "-")
 				structuredLog(LogLevelInfo, "EnvVars", "Adding new environment variable: %s", envVar.Name)
 			}
 		}
@@ -55,9 +58,7 @@ func addEnv(target, envVars []corev1.EnvVar, basePath string) (patch []patchOper
 				Path:  path,
 				Value: value,
 			})
-		} else {
-			patch = []patchOperation{}
 		}
 	}
-	return patch
+	return patch, nil
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// HostAlias is an /etc/hosts entry to inject
+type HostAlias struct {
+	corev1.HostAlias `yaml:",inline"`
+
+	MergeStrategy MergeStrategy `yaml:"mergeStrategy,omitempty" json:"mergeStrategy,omitempty"`
+}
+
+// addHostAliases performs the mutation(s) needed to add the extra host aliases to the target
+// resource. The hostnames of an IP the pod already lists are merged into its entry. A hostname
+// the pod maps to another IP conflicts with the injected entry: with the override strategy it
+// is moved to the injected IP, and an entry left without hostnames is removed.
+func addHostAliases(target []corev1.HostAlias, hostAliases []HostAlias, basePath string, strategy MergeStrategy) (patch []patchOperation, err error) {
+	first := len(target) == 0
+	if first {
+		structuredLog(LogLevelDebug, "HostAliases", "No existing host aliases found, will create new array")
+	} else {
+		structuredLog(LogLevelDebug, "HostAliases", "Found %d existing host aliases", len(target))
+	}
+
+	// the operations are computed against a copy kept in sync with the patch
+	aliases := make([]corev1.HostAlias, 0, len(target))
+	for _, alias := range target {
+		aliases = append(aliases, corev1.HostAlias{IP: alias.IP, Hostnames: append([]string(nil), alias.Hostnames...)})
+	}
+
+	for _, hostAlias := range hostAliases {
+		if first {
+			first = false
+			structuredLog(LogLevelDebug, "HostAliases", "Adding first host alias with IP: %s", hostAlias.IP)
+			patch = append(patch, patchOperation{Op: "add", Path: basePath, Value: []corev1.HostAlias{hostAlias.HostAlias}})
+			aliases = append(aliases, hostAlias.HostAlias)
+			continue
+		}
+
+		var hostnames []string
+		for _, hostname := range hostAlias.Hostnames {
+			idx, mapped := hostAliasIndex(aliases, hostname)
+			if !mapped || aliases[idx].IP == hostAlias.IP {
+				hostnames = append(hostnames, hostname)
+				continue
+			}
+			path := jsonPointer(basePath, strconv.Itoa(idx), "hostnames")
+			skip, err := resolveConflict(hostAlias.MergeStrategy.or(strategy), path, hostname)
+			if err != nil {
+				return nil, err
+			}
+			if skip {
+				structuredLog(LogLevelDebug, "HostAliases", "Keeping existing host alias %s for IP: %s", hostname, aliases[idx].IP)
+				continue
+			}
+			structuredLog(LogLevelInfo, "HostAliases", "Moving host alias %s from IP %s to IP %s", hostname, aliases[idx].IP, hostAlias.IP)
+			aliases[idx].Hostnames = removeString(aliases[idx].Hostnames, hostname)
+			if len(aliases[idx].Hostnames) == 0 {
+				// an entry without hostnames maps nothing
+				structuredLog(LogLevelInfo, "HostAliases", "Removing host alias at index %d with IP %s, all its hostnames were moved", idx, aliases[idx].IP)
+				patch = append(patch, patchOperation{Op: "remove", Path: jsonPointer(basePath, strconv.Itoa(idx))})
+				aliases = append(aliases[:idx], aliases[idx+1:]...)
+			} else {
+				patch = append(patch, patchOperation{Op: "replace", Path: path, Value: aliases[idx].Hostnames})
+			}
+			hostnames = append(hostnames, hostname)
+		}
+
+		idx := -1
+		for i, alias := range aliases {
+			if alias.IP == hostAlias.IP {
+				idx = i
+			}
+		}
+		if idx == -1 {
+			structuredLog(LogLevelInfo, "HostAliases", "Adding new host alias with IP: %s", hostAlias.IP)
+			alias := corev1.HostAlias{IP: hostAlias.IP, Hostnames: hostnames}
+			patch = append(patch, patchOperation{Op: "add", Path: jsonPointer(basePath, "-"), Value: alias})
+			aliases = append(aliases, alias)
+			continue
+		}
+
+		path := jsonPointer(basePath, strconv.Itoa(idx), "hostnames")
+		for _, hostname := range hostnames {
+			if containsString(aliases[idx].Hostnames, hostname) {
+				continue
+			}
+			structuredLog(LogLevelInfo, "HostAliases", "Adding hostname %s to existing host alias at index %d with IP: %s", hostname, idx, hostAlias.IP)
+			if len(aliases[idx].Hostnames) == 0 {
+				patch = append(patch, patchOperation{Op: "add", Path: path, Value: []string{hostname}})
+			} else {
+				patch = append(patch, patchOperation{Op: "add", Path: jsonPointer(path, "-"), Value: hostname})
+			}
+			aliases[idx].Hostnames = append(aliases[idx].Hostnames, hostname)
+		}
+	}
+	return patch, nil
+}
+
+// hostAliasIndex returns the index of the entry mapping the hostname, if any
+func hostAliasIndex(aliases []corev1.HostAlias, hostname string) (int, bool) {
+	for idx, alias := range aliases {
+		if containsString(alias.Hostnames, hostname) {
+			return idx, true
+		}
+	}
+	return -1, false
+}
+
+func removeString(list []string, s string) []string {
+	kept := []string{}
+	for _, item := range list {
+		if item != s {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// hostAliasEntries formats the host aliases, e.g. "10.0.0.5=legacy-db,legacy-db.example.com"
+func hostAliasEntries(aliases []corev1.HostAlias) []string {
+	entries := make([]string, 0, len(aliases))
+	for _, alias := range aliases {
+		entries = append(entries, alias.IP+"="+strings.Join(alias.Hostnames, ","))
+	}
+	return entries
+}
+
+func TestAddHostAliases(t *testing.T) {
+	pod := testPod(t, `
+spec:
+  hostAliases:
+    - ip: 10.0.0.5
+      hostnames: [legacy-db]
+    - ip: 10.0.0.6
+      hostnames: [legacy-cache, metrics]
+  containers:
+    - name: app
+      image: nginx
+`)
+
+	tests := []struct {
+		name    string
+		pod     *corev1.Pod
+		config  string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "first host alias",
+			pod: testPod(t, `
+spec:
+  containers:
+    - name: app
+      image: nginx
+`),
+			config: `
+hostAliases:
+  - ip: 10.1.0.5
+    hostnames: [legacy-db]
+`,
+			want: []string{"10.1.0.5=legacy-db"},
+		},
+		{
+			name: "merge into the entry of the same IP",
+			pod:  pod,
+			config: `
+hostAliases:
+  - ip: 10.0.0.5
+    hostnames: [legacy-db, legacy-db.example.com]
+`,
+			want: []string{"10.0.0.5=legacy-db,legacy-db.example.com", "10.0.0.6=legacy-cache,metrics"},
+		},
+		{
+			name: "move the last hostname of an entry",
+			pod:  pod,
+			config: `
+hostAliases:
+  - ip: 10.1.0.5
+    hostnames: [legacy-db, legacy-cache]
+`,
+			want: []string{"10.0.0.6=metrics", "10.1.0.5=legacy-db,legacy-cache"},
+		},
+		{
+			name: "move every hostname to an existing entry",
+			pod:  pod,
+			config: `
+hostAliases:
+  - ip: 10.0.0.6
+    hostnames: [legacy-db]
+  - ip: 10.0.0.7
+    hostnames: [legacy-cache, metrics]
+`,
+			want: []string{"10.0.0.6=legacy-db", "10.0.0.7=legacy-cache,metrics"},
+		},
+		{
+			name: "keepExisting",
+			pod:  pod,
+			config: `
+hostAliases:
+  - ip: 10.1.0.5
+    hostnames: [legacy-db, legacy-db.example.com]
+mergeStrategies:
+  hostAliases: keepExisting
+`,
+			want: []string{"10.0.0.5=legacy-db", "10.0.0.6=legacy-cache,metrics", "10.1.0.5=legacy-db.example.com"},
+		},
+		{
+			name: "fail",
+			pod:  pod,
+			config: `
+hostAliases:
+  - ip: 10.1.0.5
+    hostnames: [legacy-cache]
+    mergeStrategy: fail
+`,
+			wantErr: `/spec/hostAliases/1/hostnames: existing entry "legacy-cache" conflicts with the injected value (mergeStrategy: fail)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, _, err := mutateTestPod(tt.pod, testConfig(t, tt.config))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hostAliasEntries(patched.Spec.HostAliases); !equalStrings(got, tt.want) {
+				t.Errorf("got host aliases %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
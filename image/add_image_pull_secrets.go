@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ImagePullSecret is a pull secret to add to the pod, e.g. for the registry mirror images are
+// rewritten to
+type ImagePullSecret struct {
+	corev1.LocalObjectReference `yaml:",inline"`
+
+	// RequireExists skips the secret with an admission warning when it does not exist in the
+	// namespace of the pod. It requires the webhook to run with -checkReferences.
+	RequireExists bool `yaml:"requireExists,omitempty" json:"requireExists,omitempty"`
+}
+
+// availableImagePullSecrets returns the secrets to inject, without the required ones missing
+// from the namespace
+func availableImagePullSecrets(mc *mutationContext, namespace string, secrets []ImagePullSecret) ([]ImagePullSecret, error) {
+	available := make([]ImagePullSecret, 0, len(secrets))
+	for _, secret := range secrets {
+		if secret.RequireExists {
+			exists, err := mc.referenceExists(secretGVR, namespace, secret.Name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to look up Secret %s/%s: %w", namespace, secret.Name, err)
+			}
+			if !exists {
+				mc.warn("imagePullSecret %s/%s does not exist, it was not injected", namespace, secret.Name)
+				continue
+			}
+		}
+		available = append(available, secret)
+	}
+	return available, nil
+}
+
+// addImagePullSecrets performs the mutation(s) needed to add the extra pull secrets to the
+// target resource. Secrets are identified by name only, so a secret the pod already references
+// is left as is.
+func addImagePullSecrets(target []corev1.LocalObjectReference, secrets []ImagePullSecret, basePath string) (patch []patchOperation) {
+	first := len(target) == 0
+	if first {
+		structuredLog(LogLevelDebug, "ImagePullSecrets", "No existing image pull secrets found, will create new array")
+	} else {
+		structuredLog(LogLevelDebug, "ImagePullSecrets", "Found %d existing image pull secrets", len(target))
+	}
+
+	for _, secret := range secrets {
+		exists := false
+		for _, targetSecret := range target {
+			if targetSecret.Name == secret.Name {
+				exists = true
+			}
+		}
+		if exists {
+			structuredLog(LogLevelDebug, "ImagePullSecrets", "Skipping existing image pull secret: %s", secret.Name)
+			continue
+		}
+
+		if first {
+			first = false
+			structuredLog(LogLevelDebug, "ImagePullSecrets", "Adding first image pull secret: %s", secret.Name)
+			patch = append(patch, patchOperation{Op: "add", Path: basePath, Value: []corev1.LocalObjectReference{secret.LocalObjectReference}})
+		} else {
+			structuredLog(LogLevelInfo, "ImagePullSecrets", "Adding image pull secret: %s", secret.Name)
+			patch = append(patch, patchOperation{Op: "add", Path: jsonPointer(basePath, "-"), Value: secret.LocalObjectReference})
+		}
+	}
+	return patch
+}
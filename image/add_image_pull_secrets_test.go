@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// secretNames returns the names of the pull secrets in order
+func secretNames(secrets []corev1.LocalObjectReference) []string {
+	names := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		names = append(names, secret.Name)
+	}
+	return names
+}
+
+func TestAddImagePullSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		pod     string
+		config  string
+		objects []string // existing objects, enables reference checks
+		want    []string
+		warns   int
+	}{
+		{
+			name: "first secrets",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+imagePullSecrets:
+  - name: mirror-credentials
+  - name: quay-credentials
+`,
+			want: []string{"mirror-credentials", "quay-credentials"},
+		},
+		{
+			name: "secrets referenced by the pod",
+			pod: `
+spec:
+  imagePullSecrets:
+    - name: team-credentials
+    - name: mirror-credentials
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+imagePullSecrets:
+  - name: mirror-credentials
+  - name: quay-credentials
+`,
+			want: []string{"team-credentials", "mirror-credentials", "quay-credentials"},
+		},
+		{
+			name: "requireExists with reference checks",
+			pod: `
+metadata:
+  namespace: shop
+spec:
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+imagePullSecrets:
+  - name: mirror-credentials
+    requireExists: true
+  - name: in-other-namespace
+    requireExists: true
+  - name: quay-credentials
+`,
+			objects: []string{"Secret/shop/mirror-credentials", "Secret/billing/in-other-namespace"},
+			want:    []string{"mirror-credentials", "quay-credentials"},
+			warns:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := &mutationContext{}
+			if tt.objects != nil {
+				mc.objects = newTestObjectCache(t, tt.objects...)
+			}
+			patched, err := mutateTestPodContext(mc, testPod(t, tt.pod), testConfig(t, tt.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := secretNames(patched.Spec.ImagePullSecrets); !equalStrings(got, tt.want) {
+				t.Errorf("got imagePullSecrets %v, want %v", got, tt.want)
+			}
+			if len(mc.warnings) != tt.warns {
+				t.Errorf("got warnings %q, want %d", mc.warnings, tt.warns)
+			}
+		})
+	}
+}
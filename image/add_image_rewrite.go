@@ -0,0 +1,118 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// originalImageAnnotationPrefix prefixes the annotation recording the image of a container
+// before it was rewritten, e.g. original-image.envinjector.wh.net/app
+const originalImageAnnotationPrefix = "original-image.envinjector.wh.net/"
+
+// ImageRewrite rewrites the images of all containers, e.g. to pull through a registry mirror
+type ImageRewrite struct {
+	// Rules are evaluated in order and the first matching rule rewrites the image
+	Rules []ImageRewriteRule `yaml:"rules" json:"rules"`
+	// Exclude lists image patterns that are never rewritten, with the containerSelector syntax
+	Exclude []string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+	// PreserveDigest keeps the digest of images referenced by digest. Otherwise the digest is
+	// dropped, as mirrors may serve the image under another digest, and images referenced
+	// only by digest are not rewritten.
+	PreserveDigest bool `yaml:"preserveDigest,omitempty" json:"preserveDigest,omitempty"`
+
+	exclude []*regexp.Regexp // the Exclude patterns, compiled when the config is validated
+}
+
+// ImageRewriteRule replaces a prefix of the image, or the matches of a regular expression
+type ImageRewriteRule struct {
+	Prefix string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+	Regex  string `yaml:"regex,omitempty" json:"regex,omitempty"`
+	// Replacement replaces the prefix, or the regex match with $1 style references to its groups
+	Replacement string `yaml:"replacement" json:"replacement"`
+
+	regex *regexp.Regexp // the Regex, compiled when the config is validated
+}
+
+// rewrite returns the rewritten image name, and whether the rule matches
+func (r *ImageRewriteRule) rewrite(name string) (string, bool) {
+	if r.Regex != "" {
+		if !r.regex.MatchString(name) {
+			return name, false
+		}
+		return r.regex.ReplaceAllString(name, r.Replacement), true
+	}
+
+	if rest, ok := strings.CutPrefix(name, r.Prefix); ok {
+		return r.Replacement + rest, true
+	}
+	return name, false
+}
+
+// rewriteImage returns the rewritten image, and whether it was rewritten. Rules match the
+// image without its digest, with short Docker Hub names expanded, e.g. nginx:1.27 is matched
+// as docker.io/library/nginx:1.27.
+func (rw *ImageRewrite) rewriteImage(mc *mutationContext, image string) (string, bool) {
+	if matchesAnyImage(rw.exclude, image) {
+		structuredLog(LogLevelDebug, "ImageRewrite", "Image %s is excluded from rewriting", image)
+		return image, false
+	}
+
+	name, digest, hasDigest := strings.Cut(image, "@")
+	normalized := normalizeImageName(name)
+	for _, rule := range rw.Rules {
+		rewritten, ok := rule.rewrite(normalized)
+		if !ok {
+			continue
+		}
+		if hasDigest {
+			if rw.PreserveDigest {
+				rewritten += "@" + digest
+			} else if !hasImageTag(name) {
+				mc.warn("image %s is referenced only by digest and preserveDigest is disabled, it was not rewritten", image)
+				return image, false
+			}
+		}
+		return rewritten, rewritten != image
+	}
+	return image, false
+}
+
+// addImageRewrite performs the mutation(s) needed to rewrite the images of the containers of the
+// given types, skipping the containers named in skip. The original images are returned by
+// container name so they can be recorded in annotations.
+func addImageRewrite(mc *mutationContext, pod *corev1.Pod, rw *ImageRewrite, types []ContainerType, skip map[string]bool) (patch []patchOperation, originals map[string]string) {
+	originals = map[string]string{}
+	for _, c := range podContainers(&pod.Spec, types...) {
+		if skip[c.Container.Name] {
+			continue
+		}
+		image, ok := rw.rewriteImage(mc, c.Container.Image)
+		if !ok {
+			continue
+		}
+		structuredLog(LogLevelInfo, "ImageRewrite", "Rewriting image of %s container %s from %s to %s", c.Type, c.Container.Name, c.Container.Image, image)
+		patch = append(patch, patchOperation{Op: "replace", Path: jsonPointer(c.Path, "image"), Value: image})
+		originals[originalImageAnnotationPrefix+c.Container.Name] = c.Container.Image
+	}
+	return patch, originals
+}
+
+// normalizeImageName expands short Docker Hub image names to their fully qualified form
+func normalizeImageName(name string) string {
+	domain, remainder, found := strings.Cut(name, "/")
+	if !found || (!strings.ContainsAny(domain, ".:") && domain != "localhost") {
+		domain, remainder = "docker.io", name
+	}
+	if domain == "docker.io" && !strings.Contains(remainder, "/") {
+		remainder = "library/" + remainder
+	}
+	return domain + "/" + remainder
+}
+
+// hasImageTag reports whether the image name, without digest, has a tag
+func hasImageTag(name string) bool {
+	lastComponent := name[strings.LastIndex(name, "/")+1:]
+	return strings.Contains(lastComponent, ":")
+}
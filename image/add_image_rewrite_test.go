@@ -0,0 +1,162 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAddImageRewrite(t *testing.T) {
+	pod := testPod(t, `
+metadata:
+  annotations:
+    original-image.envinjector.wh.net/worker: docker.io/library/worker:0.9
+spec:
+  initContainers:
+    - name: migrate
+      image: flyway/flyway:10
+  containers:
+    - name: app
+      image: nginx:1.27
+    - name: pinned
+      image: nginx:1.27@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef
+    - name: digest-only
+      image: nginx@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef
+    - name: internal
+      image: registry.example.com/shop/checkout:1.4
+    - name: worker
+      image: mirror.local/dockerhub/library/worker:1.0
+`)
+
+	tests := []struct {
+		name        string
+		config      string
+		images      map[string]string
+		annotations map[string]string
+		warns       int
+	}{
+		{
+			name: "prefix rule",
+			config: `
+imageRewrite:
+  rules:
+    - prefix: docker.io/
+      replacement: mirror.local/dockerhub/
+`,
+			images: map[string]string{
+				"migrate":     "mirror.local/dockerhub/flyway/flyway:10",
+				"app":         "mirror.local/dockerhub/library/nginx:1.27",
+				"pinned":      "mirror.local/dockerhub/library/nginx:1.27",
+				"digest-only": "nginx@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+				"internal":    "registry.example.com/shop/checkout:1.4",
+				"worker":      "mirror.local/dockerhub/library/worker:1.0",
+			},
+			annotations: map[string]string{
+				"original-image.envinjector.wh.net/migrate": "flyway/flyway:10",
+				"original-image.envinjector.wh.net/app":     "nginx:1.27",
+				"original-image.envinjector.wh.net/pinned":  "nginx:1.27@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+				"original-image.envinjector.wh.net/worker":  "docker.io/library/worker:0.9",
+			},
+			warns: 1,
+		},
+		{
+			name: "regex rule, digests and exclusions",
+			config: `
+imageRewrite:
+  rules:
+    - regex: ^docker\.io/library/(.*)$
+      replacement: mirror.local/library/$1
+  exclude: ["nginx:1.27"]
+  preserveDigest: true
+`,
+			images: map[string]string{
+				"migrate":     "flyway/flyway:10",
+				"app":         "nginx:1.27",
+				"pinned":      "mirror.local/library/nginx:1.27@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+				"digest-only": "mirror.local/library/nginx@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+				"internal":    "registry.example.com/shop/checkout:1.4",
+				"worker":      "mirror.local/dockerhub/library/worker:1.0",
+			},
+			annotations: map[string]string{
+				"original-image.envinjector.wh.net/pinned":      "nginx:1.27@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+				"original-image.envinjector.wh.net/digest-only": "nginx@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+				"original-image.envinjector.wh.net/worker":      "docker.io/library/worker:0.9",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, mc := mustMutateTestPod(t, pod, testConfig(t, tt.config))
+			for _, c := range podContainers(&patched.Spec, allContainerTypes...) {
+				if c.Container.Image != tt.images[c.Container.Name] {
+					t.Errorf("container %s: got image %s, want %s", c.Container.Name, c.Container.Image, tt.images[c.Container.Name])
+				}
+			}
+			if !equalStringMaps(patched.Annotations, tt.annotations) {
+				t.Errorf("got annotations %v, want %v", patched.Annotations, tt.annotations)
+			}
+			if len(mc.warnings) != tt.warns {
+				t.Errorf("got warnings %q, want %d", mc.warnings, tt.warns)
+			}
+		})
+	}
+}
+
+func TestAddImageRewriteSidecars(t *testing.T) {
+	pod := testPod(t, `
+spec:
+  initContainers:
+    - name: migrate
+      image: flyway/flyway:10
+  containers:
+    - name: app
+      image: registry.example.com/shop/checkout:1.4
+`)
+	cfg := testConfig(t, `
+sidecars:
+  - name: log-shipper
+    image: fluent/fluent-bit:3.1
+  - name: proxy
+    image: envoyproxy/envoy:v1.31
+    nativeSidecar: true
+imageRewrite:
+  rules:
+    - prefix: docker.io/
+      replacement: mirror.local/dockerhub/
+`)
+
+	patched, _ := mustMutateTestPod(t, pod, cfg)
+	want := map[string]string{
+		"proxy":       "mirror.local/dockerhub/envoyproxy/envoy:v1.31",
+		"migrate":     "mirror.local/dockerhub/flyway/flyway:10",
+		"app":         "registry.example.com/shop/checkout:1.4",
+		"log-shipper": "mirror.local/dockerhub/fluent/fluent-bit:3.1",
+	}
+	for _, c := range podContainers(&patched.Spec, allContainerTypes...) {
+		if c.Container.Image != want[c.Container.Name] {
+			t.Errorf("container %s: got image %s, want %s", c.Container.Name, c.Container.Image, want[c.Container.Name])
+		}
+	}
+	wantAnnotations := map[string]string{
+		"original-image.envinjector.wh.net/proxy":       "envoyproxy/envoy:v1.31",
+		"original-image.envinjector.wh.net/migrate":     "flyway/flyway:10",
+		"original-image.envinjector.wh.net/log-shipper": "fluent/fluent-bit:3.1",
+	}
+	if !equalStringMaps(patched.Annotations, wantAnnotations) {
+		t.Errorf("got annotations %v, want %v", patched.Annotations, wantAnnotations)
+	}
+}
+
+func TestNormalizeImageName(t *testing.T) {
+	tests := map[string]string{
+		"nginx":                           "docker.io/library/nginx",
+		"bitnami/redis:7":                 "docker.io/bitnami/redis:7",
+		"docker.io/nginx:1.27":            "docker.io/library/nginx:1.27",
+		"localhost/app":                   "localhost/app",
+		"registry.example.com:5000/app:1": "registry.example.com:5000/app:1",
+	}
+	for name, want := range tests {
+		if got := normalizeImageName(name); got != want {
+			t.Errorf("normalizeImageName(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
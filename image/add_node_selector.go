@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// addNodeSelector merges the nodeSelector block into the pod. A nodeSelector contradicting the
+// required node affinity of the pod, including the terms injected by the profile, would leave
+// the pod unschedulable, so it is skipped with an admission warning, or rejected with the fail
+// merge strategy.
+func addNodeSelector(mc *mutationContext, spec *corev1.PodSpec, envConfig *Config) ([]patchOperation, error) {
+	strategy := envConfig.MergeStrategies.NodeSelector
+
+	var terms []corev1.NodeSelectorTerm
+	if spec.Affinity != nil && spec.Affinity.NodeAffinity != nil && spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		terms = append(terms, spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms...)
+	}
+	terms = append(terms, envConfig.RequiredNodeAffinityTerms...)
+
+	if reason, conflict := nodeSelectorConflict(mergedNodeSelector(spec.NodeSelector, envConfig.NodeSelector, strategy), terms); conflict {
+		if strategy == MergeStrategyFail {
+			return nil, fmt.Errorf("/spec/nodeSelector: %s (mergeStrategy: %s)", reason, strategy)
+		}
+		mc.warn("%s, the nodeSelector was not injected", reason)
+		return nil, nil
+	}
+	return addStringMap(spec.NodeSelector, envConfig.NodeSelector, "/spec/nodeSelector", strategy)
+}
+
+// mergedNodeSelector returns the nodeSelector of the pod once the injected entries are merged
+// with the strategy
+func mergedNodeSelector(existing, injected map[string]string, strategy MergeStrategy) map[string]string {
+	merged := make(map[string]string, len(existing)+len(injected))
+	for key, value := range existing {
+		merged[key] = value
+	}
+	for key, value := range injected {
+		if _, ok := existing[key]; ok && strategy == MergeStrategyKeepExisting {
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+// nodeSelectorConflict reports whether no node can satisfy both the nodeSelector and the
+// required node affinity terms, with the reason. Terms are ORed so the selector only conflicts
+// when it contradicts every term. Expressions on labels absent from the selector can still be
+// satisfied by some node and are ignored.
+func nodeSelectorConflict(selector map[string]string, terms []corev1.NodeSelectorTerm) (string, bool) {
+	if len(selector) == 0 || len(terms) == 0 {
+		return "", false
+	}
+
+	var reason string
+	for _, term := range terms {
+		termReason, conflict := nodeSelectorTermConflict(selector, term)
+		if !conflict {
+			return "", false
+		}
+		if reason == "" {
+			reason = termReason
+		}
+	}
+	return reason, true
+}
+
+func nodeSelectorTermConflict(selector map[string]string, term corev1.NodeSelectorTerm) (string, bool) {
+	for _, req := range term.MatchExpressions {
+		value, ok := selector[req.Key]
+		if !ok {
+			continue
+		}
+		if !nodeSelectorRequirementMatches(req, value) {
+			return fmt.Sprintf("nodeSelector %s=%s contradicts required node affinity %s %s %v", req.Key, value, req.Key, req.Operator, req.Values), true
+		}
+	}
+	return "", false
+}
+
+// nodeSelectorRequirementMatches reports whether a node labelled with value satisfies req
+func nodeSelectorRequirementMatches(req corev1.NodeSelectorRequirement, value string) bool {
+	switch req.Operator {
+	case corev1.NodeSelectorOpIn:
+		return containsString(req.Values, value)
+	case corev1.NodeSelectorOpNotIn:
+		return !containsString(req.Values, value)
+	case corev1.NodeSelectorOpExists:
+		return true
+	case corev1.NodeSelectorOpDoesNotExist:
+		return false
+	case corev1.NodeSelectorOpGt, corev1.NodeSelectorOpLt:
+		if len(req.Values) != 1 {
+			return false
+		}
+		labelValue, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false
+		}
+		bound, err := strconv.ParseInt(req.Values[0], 10, 64)
+		if err != nil {
+			return false
+		}
+		if req.Operator == corev1.NodeSelectorOpGt {
+			return labelValue > bound
+		}
+		return labelValue < bound
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddNodeSelector(t *testing.T) {
+	tests := []struct {
+		name    string
+		pod     string
+		config  string
+		want    map[string]string
+		warns   int
+		wantErr string
+	}{
+		{
+			name: "merge into existing selector",
+			pod: `
+spec:
+  nodeSelector:
+    kubernetes.io/os: linux
+    node.example.com/pool: general
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+nodeSelector:
+  node.example.com/pool: spot
+  kubernetes.io/arch: amd64
+`,
+			want: map[string]string{"kubernetes.io/os": "linux", "node.example.com/pool": "spot", "kubernetes.io/arch": "amd64"},
+		},
+		{
+			name: "keepExisting",
+			pod: `
+spec:
+  nodeSelector:
+    node.example.com/pool: general
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+nodeSelector:
+  node.example.com/pool: spot
+mergeStrategies:
+  nodeSelector: keepExisting
+`,
+			want: map[string]string{"node.example.com/pool": "general"},
+		},
+		{
+			name: "contradicts required node affinity of the pod",
+			pod: `
+spec:
+  affinity:
+    nodeAffinity:
+      requiredDuringSchedulingIgnoredDuringExecution:
+        nodeSelectorTerms:
+          - matchExpressions:
+              - key: node.example.com/pool
+                operator: In
+                values: [general, batch]
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+nodeSelector:
+  node.example.com/pool: spot
+`,
+			want:  map[string]string{},
+			warns: 1,
+		},
+		{
+			name: "satisfies one of the required terms",
+			pod: `
+spec:
+  affinity:
+    nodeAffinity:
+      requiredDuringSchedulingIgnoredDuringExecution:
+        nodeSelectorTerms:
+          - matchExpressions:
+              - key: node.example.com/pool
+                operator: In
+                values: [general]
+          - matchExpressions:
+              - key: node.example.com/cpu-count
+                operator: Gt
+                values: ["8"]
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+nodeSelector:
+  node.example.com/pool: spot
+`,
+			want: map[string]string{"node.example.com/pool": "spot"},
+		},
+		{
+			name: "keepExisting selector satisfying the affinity",
+			pod: `
+spec:
+  nodeSelector:
+    node.example.com/pool: general
+  affinity:
+    nodeAffinity:
+      requiredDuringSchedulingIgnoredDuringExecution:
+        nodeSelectorTerms:
+          - matchExpressions:
+              - key: node.example.com/pool
+                operator: NotIn
+                values: [spot]
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+nodeSelector:
+  node.example.com/pool: spot
+mergeStrategies:
+  nodeSelector: keepExisting
+`,
+			want: map[string]string{"node.example.com/pool": "general"},
+		},
+		{
+			name: "contradiction with fail",
+			pod: `
+spec:
+  affinity:
+    nodeAffinity:
+      requiredDuringSchedulingIgnoredDuringExecution:
+        nodeSelectorTerms:
+          - matchExpressions:
+              - key: node.example.com/pool
+                operator: DoesNotExist
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+nodeSelector:
+  node.example.com/pool: spot
+mergeStrategies:
+  nodeSelector: fail
+`,
+			wantErr: "/spec/nodeSelector: nodeSelector node.example.com/pool=spot contradicts required node affinity node.example.com/pool DoesNotExist []",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, mc, err := mutateTestPod(testPod(t, tt.pod), testConfig(t, tt.config))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalStringMaps(patched.Spec.NodeSelector, tt.want) {
+				t.Errorf("got nodeSelector %v, want %v", patched.Spec.NodeSelector, tt.want)
+			}
+			if len(mc.warnings) != tt.warns {
+				t.Errorf("got warnings %q, want %d", mc.warnings, tt.warns)
+			}
+		})
+	}
+}
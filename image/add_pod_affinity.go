@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodAffinityTerms are the pod affinity or anti-affinity terms to inject. Label selector values
+// may be templates expanded against the pod, e.g. `{{ .Labels.app }}` to spread the replicas of
+// every workload across hosts with a single term.
+type PodAffinityTerms struct {
+	Required  []corev1.PodAffinityTerm         `yaml:"required,omitempty" json:"required,omitempty"`
+	Preferred []corev1.WeightedPodAffinityTerm `yaml:"preferred,omitempty" json:"preferred,omitempty"`
+}
+
+// addPodAffinity performs the mutation(s) needed to add the pod affinity and anti-affinity terms,
+// creating the parents of the terms when the pod has none
+func addPodAffinity(mc *mutationContext, pod *corev1.Pod, envConfig *Config) ([]patchOperation, error) {
+	var patch []patchOperation
+	for _, block := range []struct {
+		name  string
+		terms *PodAffinityTerms
+	}{
+		{"podAffinity", envConfig.PodAffinityTerms},
+		{"podAntiAffinity", envConfig.PodAntiAffinityTerms},
+	} {
+		if block.terms == nil {
+			continue
+		}
+		required, preferred, err := renderPodAffinityTerms(mc, block.name, block.terms, pod)
+		if err != nil {
+			return nil, err
+		}
+		if len(required) == 0 && len(preferred) == 0 {
+			continue
+		}
+
+		if pod.Spec.Affinity == nil {
+			pod.Spec.Affinity = &corev1.Affinity{}
+			patch = append(patch, patchOperation{Op: "add", Path: "/spec/affinity", Value: corev1.Affinity{}})
+		}
+		var target *corev1.PodAffinity
+		switch block.name {
+		case "podAffinity":
+			if pod.Spec.Affinity.PodAffinity == nil {
+				pod.Spec.Affinity.PodAffinity = &corev1.PodAffinity{}
+				patch = append(patch, patchOperation{Op: "add", Path: "/spec/affinity/podAffinity", Value: corev1.PodAffinity{}})
+			}
+			target = pod.Spec.Affinity.PodAffinity
+		case "podAntiAffinity":
+			if pod.Spec.Affinity.PodAntiAffinity == nil {
+				pod.Spec.Affinity.PodAntiAffinity = &corev1.PodAntiAffinity{}
+				patch = append(patch, patchOperation{Op: "add", Path: "/spec/affinity/podAntiAffinity", Value: corev1.PodAntiAffinity{}})
+			}
+			// PodAntiAffinity has the same fields as PodAffinity
+			target = (*corev1.PodAffinity)(pod.Spec.Affinity.PodAntiAffinity)
+		}
+
+		basePath := jsonPointer("/spec/affinity", block.name)
+		patch = append(patch, addTerms(target.RequiredDuringSchedulingIgnoredDuringExecution, required,
+			jsonPointer(basePath, "requiredDuringSchedulingIgnoredDuringExecution"), block.name)...)
+		patch = append(patch, addTerms(target.PreferredDuringSchedulingIgnoredDuringExecution, preferred,
+			jsonPointer(basePath, "preferredDuringSchedulingIgnoredDuringExecution"), block.name)...)
+	}
+	return patch, nil
+}
+
+// renderPodAffinityTerms expands the templated label selectors of the terms. A term referencing
+// a label the pod does not have is skipped with an admission warning, as it would select
+// unrelated pods.
+func renderPodAffinityTerms(mc *mutationContext, name string, terms *PodAffinityTerms, pod *corev1.Pod) ([]corev1.PodAffinityTerm, []corev1.WeightedPodAffinityTerm, error) {
+	data := newEnvTemplateData(pod, &corev1.Container{})
+	expand := func(value string) (string, error) {
+		return executeEnvTemplate(name, value, data)
+	}
+
+	var required []corev1.PodAffinityTerm
+	for _, term := range terms.Required {
+		rendered, err := renderTemplates(term, expand)
+		if err != nil {
+			mc.warn("required %s term with topology key %s was not injected: %v", name, term.TopologyKey, err)
+			continue
+		}
+		required = append(required, rendered)
+	}
+	var preferred []corev1.WeightedPodAffinityTerm
+	for _, term := range terms.Preferred {
+		rendered, err := renderTemplates(term, expand)
+		if err != nil {
+			mc.warn("preferred %s term with topology key %s was not injected: %v", name, term.PodAffinityTerm.TopologyKey, err)
+			continue
+		}
+		preferred = append(preferred, rendered)
+	}
+	return required, preferred, nil
+}
+
+// renderTemplates returns a copy of v with every templated string field expanded by fn
+func renderTemplates[T any](v T, fn func(string) (string, error)) (T, error) {
+	var rendered T
+	value, err := jsonValue(v)
+	if err != nil {
+		return rendered, err
+	}
+	if value, err = walkTemplateValues(value, fn); err != nil {
+		return rendered, err
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return rendered, err
+	}
+	if err := json.Unmarshal(raw, &rendered); err != nil {
+		return rendered, fmt.Errorf("failed to decode expanded term: %w", err)
+	}
+	return rendered, nil
+}
+
+// addTerms performs the mutation(s) needed to add the terms missing from the target array.
+// Terms have no identifying key, so only identical terms are de-duplicated.
+func addTerms[T any](target, terms []T, basePath, name string) (patch []patchOperation) {
+	first := len(target) == 0
+	for _, term := range terms {
+		exists := false
+		for _, targetTerm := range target {
+			if cmp.Equal(targetTerm, term) {
+				exists = true
+			}
+		}
+		if exists {
+			structuredLog(LogLevelDebug, "PodAffinity", "Skipping existing %s term at %s", name, basePath)
+			continue
+		}
+
+		if first {
+			first = false
+			structuredLog(LogLevelDebug, "PodAffinity", "Adding first %s term at %s", name, basePath)
+			patch = append(patch, patchOperation{Op: "add", Path: basePath, Value: []T{term}})
+		} else {
+			structuredLog(LogLevelInfo, "PodAffinity", "Adding %s term at %s", name, basePath)
+			patch = append(patch, patchOperation{Op: "add", Path: jsonPointer(basePath, "-"), Value: term})
+		}
+	}
+	return patch
+}
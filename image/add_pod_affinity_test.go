@@ -0,0 +1,178 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAddPodAffinity(t *testing.T) {
+	tests := []struct {
+		name  string
+		pod   string
+		want  []string // anti-affinity terms, as formatted by antiAffinityTerms
+		app   string   // label selector value of the injected term
+		warns int
+	}{
+		{
+			name: "pod without affinity",
+			pod: `
+metadata:
+  labels:
+    app: checkout
+spec:
+  containers:
+    - name: app
+      image: nginx
+`,
+			want: []string{"required:kubernetes.io/hostname", "preferred/50:topology.kubernetes.io/zone"},
+			app:  "checkout",
+		},
+		{
+			name: "pod with node affinity only",
+			pod: `
+metadata:
+  labels:
+    app: checkout
+spec:
+  containers:
+    - name: app
+      image: nginx
+  affinity:
+    nodeAffinity:
+      preferredDuringSchedulingIgnoredDuringExecution:
+        - weight: 10
+          preference:
+            matchExpressions:
+              - key: node.example.com/pool
+                operator: In
+                values: [spot]
+`,
+			want: []string{"required:kubernetes.io/hostname", "preferred/50:topology.kubernetes.io/zone"},
+			app:  "checkout",
+		},
+		{
+			name: "existing terms",
+			pod: `
+metadata:
+  labels:
+    app: checkout
+spec:
+  containers:
+    - name: app
+      image: nginx
+  affinity:
+    podAntiAffinity:
+      requiredDuringSchedulingIgnoredDuringExecution:
+        - topologyKey: kubernetes.io/hostname
+          labelSelector:
+            matchLabels:
+              app: checkout
+        - topologyKey: topology.kubernetes.io/zone
+          labelSelector:
+            matchLabels:
+              tier: db
+`,
+			want: []string{"required:kubernetes.io/hostname", "required:topology.kubernetes.io/zone", "preferred/50:topology.kubernetes.io/zone"},
+			app:  "checkout",
+		},
+		{
+			name: "pod without the templated label",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+`,
+			want:  []string{},
+			warns: 2,
+		},
+	}
+
+	cfg := testConfig(t, `
+podAntiAffinityTerms:
+  required:
+    - topologyKey: kubernetes.io/hostname
+      labelSelector:
+        matchLabels:
+          app: "{{ .Labels.app }}"
+  preferred:
+    - weight: 50
+      podAffinityTerm:
+        topologyKey: topology.kubernetes.io/zone
+        labelSelector:
+          matchLabels:
+            app: "{{ .Labels.app }}"
+`)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, mc := mustMutateTestPod(t, testPod(t, tt.pod), cfg)
+			if got := antiAffinityTerms(patched.Spec); !equalStrings(got, tt.want) {
+				t.Errorf("got anti-affinity terms %v, want %v", got, tt.want)
+			}
+			if tt.app != "" {
+				term := patched.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0]
+				if got := term.LabelSelector.MatchLabels["app"]; got != tt.app {
+					t.Errorf("got label selector app=%s, want %s", got, tt.app)
+				}
+			}
+			if patched.Spec.Affinity != nil && patched.Spec.Affinity.PodAffinity != nil {
+				t.Errorf("got pod affinity %+v, want none", patched.Spec.Affinity.PodAffinity)
+			}
+			if len(mc.warnings) != tt.warns {
+				t.Errorf("got warnings %q, want %d", mc.warnings, tt.warns)
+			}
+		})
+	}
+}
+
+func TestAddPodAffinityAfterRewrite(t *testing.T) {
+	pod := testPod(t, `
+metadata:
+  labels:
+    app: checkout
+spec:
+  containers:
+    - name: app
+      image: nginx
+  affinity:
+    podAffinity:
+      preferredDuringSchedulingIgnoredDuringExecution:
+        - weight: 10
+          podAffinityTerm:
+            topologyKey: topology.kubernetes.io/zone
+            labelSelector:
+              matchLabels:
+                app: cache
+    podAntiAffinity:
+      requiredDuringSchedulingIgnoredDuringExecution:
+        - topologyKey: topology.kubernetes.io/zone
+          labelSelector:
+            matchLabels:
+              app: checkout
+`)
+	cfg := testConfig(t, `
+podAntiAffinity:
+  mode: remove
+podAffinityTerms:
+  required:
+    - topologyKey: topology.kubernetes.io/zone
+      labelSelector:
+        matchLabels:
+          app: database
+podAntiAffinityTerms:
+  required:
+    - topologyKey: kubernetes.io/hostname
+      labelSelector:
+        matchLabels:
+          app: "{{ .Labels.app }}"
+`)
+
+	patched, _ := mustMutateTestPod(t, pod, cfg)
+	if got, want := antiAffinityTerms(patched.Spec), []string{"required:kubernetes.io/hostname"}; !equalStrings(got, want) {
+		t.Errorf("got anti-affinity terms %v, want %v", got, want)
+	}
+	podAffinity := patched.Spec.Affinity.PodAffinity
+	if len(podAffinity.RequiredDuringSchedulingIgnoredDuringExecution) != 1 || len(podAffinity.PreferredDuringSchedulingIgnoredDuringExecution) != 1 {
+		t.Errorf("got pod affinity %+v, want the injected required term and the existing preferred term", podAffinity)
+	}
+}
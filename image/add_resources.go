@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// defaultedResources lists the resources the resources block may set
+var defaultedResources = []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourceEphemeralStorage}
+
+// ResourceDefaults sets the requests and limits of the selected containers that do not
+// declare them, and optionally keeps all requests and limits within bounds
+type ResourceDefaults struct {
+	ContainerTarget `yaml:",inline"`
+
+	// Requests and Limits are set for the resources the container does not request or limit
+	Requests corev1.ResourceList `yaml:"requests,omitempty" json:"requests,omitempty"`
+	Limits   corev1.ResourceList `yaml:"limits,omitempty" json:"limits,omitempty"`
+	// Min and Max clamp every request and limit, including the ones declared by the container
+	Min corev1.ResourceList `yaml:"min,omitempty" json:"min,omitempty"`
+	Max corev1.ResourceList `yaml:"max,omitempty" json:"max,omitempty"`
+	// LimitRequestRatio derives a missing limit without default from the request, and lowers
+	// limits exceeding request × ratio
+	LimitRequestRatio map[corev1.ResourceName]float64 `yaml:"limitRequestRatio,omitempty" json:"limitRequestRatio,omitempty"`
+}
+
+// containerResources computes the requests and limits of the container with the defaults,
+// bounds and ratio applied. It returns the changed fields, e.g. "requests.cpu". Only min and
+// max move a value declared by the container: a default or derived limit lower than the
+// declared request is raised to the request.
+func (rd *ResourceDefaults) containerResources(mc *mutationContext, container *corev1.Container) (corev1.ResourceRequirements, []string) {
+	requests := container.Resources.Requests.DeepCopy()
+	limits := container.Resources.Limits.DeepCopy()
+	if requests == nil {
+		requests = corev1.ResourceList{}
+	}
+	if limits == nil {
+		limits = corev1.ResourceList{}
+	}
+
+	var changed []string
+	set := func(list corev1.ResourceList, kind string, name corev1.ResourceName, value resource.Quantity) {
+		if current, ok := list[name]; ok && current.Cmp(value) == 0 {
+			return
+		}
+		list[name] = value
+		changed = append(changed, kind+"."+string(name))
+	}
+
+	for _, name := range defaultedResources {
+		request, hasRequest := requests[name]
+		declaredRequest := hasRequest
+		if !hasRequest {
+			if _, hasLimit := limits[name]; !hasLimit {
+				// the API server defaults a missing request to the limit
+				request, hasRequest = rd.Requests[name]
+			}
+		}
+		if hasRequest {
+			set(requests, "requests", name, rd.clamp(name, request))
+			request = requests[name]
+		}
+
+		limit, hasLimit := limits[name]
+		declaredLimit := hasLimit
+		ratio, hasRatio := rd.LimitRequestRatio[name]
+		if !hasLimit {
+			limit, hasLimit = rd.Limits[name]
+		}
+		if !hasLimit && hasRatio && hasRequest {
+			limit, hasLimit = scaleQuantity(name, request, ratio), true
+		}
+		if !hasLimit {
+			continue
+		}
+		limit = rd.clamp(name, limit)
+		if hasRatio && hasRequest {
+			if maxLimit := scaleQuantity(name, request, ratio); limit.Cmp(maxLimit) > 0 {
+				limit = maxLimit
+			}
+		}
+		if hasRequest && request.Cmp(limit) > 0 {
+			// a request may not exceed its limit
+			switch {
+			case !declaredRequest:
+				set(requests, "requests", name, limit)
+			case !declaredLimit:
+				mc.warn("default %s limit %s of container %s is lower than its request %s, the limit was raised to the request",
+					name, limit.String(), container.Name, request.String())
+				limit = request
+			}
+		}
+		set(limits, "limits", name, limit)
+	}
+
+	resources := corev1.ResourceRequirements{Requests: requests, Limits: limits}
+	if len(requests) == 0 {
+		resources.Requests = nil
+	}
+	if len(limits) == 0 {
+		resources.Limits = nil
+	}
+	return resources, changed
+}
+
+// clamp keeps the quantity within the configured min and max
+func (rd *ResourceDefaults) clamp(name corev1.ResourceName, q resource.Quantity) resource.Quantity {
+	if minimum, ok := rd.Min[name]; ok && q.Cmp(minimum) < 0 {
+		return minimum.DeepCopy()
+	}
+	if maximum, ok := rd.Max[name]; ok && q.Cmp(maximum) > 0 {
+		return maximum.DeepCopy()
+	}
+	return q
+}
+
+// scaleQuantity returns q × ratio, in millicores for cpu and in units otherwise
+func scaleQuantity(name corev1.ResourceName, q resource.Quantity, ratio float64) resource.Quantity {
+	if name == corev1.ResourceCPU {
+		return *resource.NewMilliQuantity(int64(float64(q.MilliValue())*ratio), q.Format)
+	}
+	return *resource.NewQuantity(int64(float64(q.Value())*ratio), q.Format)
+}
+
+// addResources performs the mutation(s) needed to set the container requests and limits. Only
+// the changed quantities are patched, existing lists are never replaced as a whole.
+func addResources(container *corev1.Container, resources corev1.ResourceRequirements, basePath string) (patch []patchOperation) {
+	lists := []struct {
+		kind     string
+		existing corev1.ResourceList
+		updated  corev1.ResourceList
+	}{
+		{"requests", container.Resources.Requests, resources.Requests},
+		{"limits", container.Resources.Limits, resources.Limits},
+	}
+
+	for _, list := range lists {
+		if len(list.updated) == 0 {
+			continue
+		}
+		path := jsonPointer(basePath, list.kind)
+		if list.existing == nil {
+			structuredLog(LogLevelInfo, "Resources", "Adding %s to container %s: %v", list.kind, container.Name, list.updated)
+			patch = append(patch, patchOperation{Op: "add", Path: path, Value: list.updated})
+			continue
+		}
+		for _, name := range defaultedResources {
+			value, ok := list.updated[name]
+			if !ok {
+				continue
+			}
+			current, exists := list.existing[name]
+			if exists && current.Cmp(value) == 0 {
+				continue
+			}
+			op := "add"
+			if exists {
+				op = "replace"
+			}
+			structuredLog(LogLevelInfo, "Resources", "Setting %s.%s of container %s to %s", list.kind, name, container.Name, value.String())
+			patch = append(patch, patchOperation{Op: op, Path: jsonPointer(path, string(name)), Value: value})
+		}
+	}
+	return patch
+}
+
+// resourceSummary formats the changed fields of a container for the status annotation,
+// e.g. "app:requests.cpu+limits.cpu"
+func resourceSummary(containerName string, changed []string) string {
+	return fmt.Sprintf("%s:%s", containerName, strings.Join(changed, "+"))
+}
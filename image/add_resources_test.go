@@ -0,0 +1,210 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// resourceValues formats the requests and limits of a container, e.g.
+// "limits.cpu=500m requests.cpu=100m"
+func resourceValues(container corev1.Container) string {
+	var values []string
+	for kind, list := range map[string]corev1.ResourceList{"requests": container.Resources.Requests, "limits": container.Resources.Limits} {
+		for name, quantity := range list {
+			values = append(values, kind+"."+string(name)+"="+quantity.String())
+		}
+	}
+	sort.Strings(values)
+	return strings.Join(values, " ")
+}
+
+func TestAddResources(t *testing.T) {
+	tests := []struct {
+		name     string
+		pod      string
+		config   string
+		want     map[string]string
+		summary  []string
+		warnings int
+	}{
+		{
+			name: "defaults for missing resources",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+    - name: worker
+      image: worker
+      resources:
+        requests:
+          cpu: 250m
+`,
+			config: `
+resources:
+  requests:
+    cpu: 100m
+    memory: 128Mi
+  limits:
+    memory: 256Mi
+`,
+			want: map[string]string{
+				"app":    "limits.memory=256Mi requests.cpu=100m requests.memory=128Mi",
+				"worker": "limits.memory=256Mi requests.cpu=250m requests.memory=128Mi",
+			},
+			summary: []string{"app:requests.cpu+requests.memory+limits.memory", "worker:requests.memory+limits.memory"},
+		},
+		{
+			name: "request defaulted to the limit by the API server",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+      resources:
+        limits:
+          cpu: "1"
+`,
+			config: `
+resources:
+  requests:
+    cpu: 100m
+`,
+			want:    map[string]string{"app": "limits.cpu=1"},
+			summary: []string{},
+		},
+		{
+			name: "min and max clamp declared values",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+      resources:
+        requests:
+          cpu: 10m
+          memory: 8Gi
+        limits:
+          memory: 16Gi
+`,
+			config: `
+resources:
+  min:
+    cpu: 50m
+  max:
+    memory: 4Gi
+`,
+			want:    map[string]string{"app": "limits.memory=4Gi requests.cpu=50m requests.memory=4Gi"},
+			summary: []string{"app:requests.cpu+requests.memory+limits.memory"},
+		},
+		{
+			name: "limit request ratio",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+      resources:
+        requests:
+          cpu: 200m
+          memory: 256Mi
+        limits:
+          memory: 2Gi
+`,
+			config: `
+resources:
+  limitRequestRatio:
+    cpu: 2.5
+    memory: 2
+`,
+			want:    map[string]string{"app": "limits.cpu=500m limits.memory=512Mi requests.cpu=200m requests.memory=256Mi"},
+			summary: []string{"app:limits.cpu+limits.memory"},
+		},
+		{
+			name: "default limit lower than the declared request",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+      resources:
+        requests:
+          memory: 4Gi
+`,
+			config: `
+resources:
+  limits:
+    memory: 1Gi
+`,
+			want:     map[string]string{"app": "limits.memory=4Gi requests.memory=4Gi"},
+			summary:  []string{"app:limits.memory"},
+			warnings: 1,
+		},
+		{
+			name: "container selector",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+    - name: istio-proxy
+      image: istio/proxyv2
+`,
+			config: `
+resources:
+  requests:
+    cpu: 100m
+  containerSelector:
+    excludeNames: [istio-proxy]
+`,
+			want:    map[string]string{"app": "requests.cpu=100m", "istio-proxy": ""},
+			summary: []string{"app:requests.cpu"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, mc := mustMutateTestPod(t, testPod(t, tt.pod), testConfig(t, tt.config))
+			for _, container := range patched.Spec.Containers {
+				if got := resourceValues(container); got != tt.want[container.Name] {
+					t.Errorf("container %s: got %q, want %q", container.Name, got, tt.want[container.Name])
+				}
+			}
+			if tt.summary != nil && !equalStrings(mc.resources, tt.summary) {
+				t.Errorf("got summary %q, want %q", mc.resources, tt.summary)
+			}
+			if len(mc.warnings) != tt.warnings {
+				t.Errorf("got warnings %q, want %d", mc.warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestResourcesStatusAnnotation(t *testing.T) {
+	pod := testPod(t, `
+spec:
+  containers:
+    - name: app
+      image: nginx
+`)
+	cfg := testConfig(t, `
+resources:
+  requests:
+    cpu: 100m
+`)
+	annotations := map[string]string{admissionWebhookAnnotationStatusKey: "injected"}
+	patches, err := createPatchOperations(&mutationContext{}, pod.DeepCopy(), []Profile{{Name: "test", Config: cfg}}, annotations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := applyPatch(pod, patches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := patched.Annotations[admissionWebhookAnnotationStatusKey], "injected; resources=app:requests.cpu"; got != want {
+		t.Errorf("got status %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import corev1 "k8s.io/api/core/v1"
+
+// SchedulingField is a pod spec field set when the pod does not set it, or always when forced
+type SchedulingField struct {
+	Value string `yaml:"value" json:"value"`
+	// Force replaces the value set by the pod
+	Force bool `yaml:"force,omitempty" json:"force,omitempty"`
+}
+
+// SchedulingGates are scheduling gates added to pods without gates, or to every pod when forced
+type SchedulingGates struct {
+	Names []string `yaml:"names" json:"names"`
+	// Force adds the missing gates to pods that already declare other gates
+	Force bool `yaml:"force,omitempty" json:"force,omitempty"`
+}
+
+// addSchedulingFields performs the mutation(s) needed to set the pod-level scheduling fields
+func addSchedulingFields(spec *corev1.PodSpec, envConfig *Config) (patch []patchOperation) {
+	var preemptionPolicy string
+	if spec.PreemptionPolicy != nil && *spec.PreemptionPolicy != corev1.PreemptLowerPriority {
+		// PreemptLowerPriority is set by the Priority admission plugin before admission when the
+		// class does not set a policy, so the pod did not choose it
+		preemptionPolicy = string(*spec.PreemptionPolicy)
+	}
+
+	if ops := setSchedulingField(spec.PriorityClassName, envConfig.PriorityClassName, "/spec/priorityClassName"); len(ops) > 0 {
+		patch = append(patch, ops...)
+		// the Priority admission plugin resolved priority and preemptionPolicy from the previous
+		// class before the webhook was called; it recomputes them when the admission chain is
+		// reinvoked and rejects values that do not match the new class, so the webhook must be
+		// registered with reinvocationPolicy IfNeeded
+		if spec.Priority != nil {
+			patch = append(patch, patchOperation{Op: "remove", Path: "/spec/priority"})
+		}
+		if spec.PreemptionPolicy != nil {
+			if envConfig.PreemptionPolicy == nil {
+				patch = append(patch, patchOperation{Op: "remove", Path: "/spec/preemptionPolicy"})
+			}
+			preemptionPolicy = ""
+		}
+	}
+
+	schedulerName := spec.SchedulerName
+	if schedulerName == corev1.DefaultSchedulerName {
+		// set by API defaulting before admission, so the pod did not choose it
+		schedulerName = ""
+	}
+	patch = append(patch, setSchedulingField(schedulerName, envConfig.SchedulerName, "/spec/schedulerName")...)
+
+	if ops := setSchedulingField(stringValue(spec.RuntimeClassName), envConfig.RuntimeClassName, "/spec/runtimeClassName"); len(ops) > 0 {
+		patch = append(patch, ops...)
+		// likewise the RuntimeClass admission plugin recomputes the overhead of the new class
+		if spec.Overhead != nil {
+			patch = append(patch, patchOperation{Op: "remove", Path: "/spec/overhead"})
+		}
+	}
+
+	patch = append(patch, setSchedulingField(preemptionPolicy, envConfig.PreemptionPolicy, "/spec/preemptionPolicy")...)
+	if envConfig.SchedulingGates != nil {
+		patch = append(patch, addSchedulingGates(spec.SchedulingGates, envConfig.SchedulingGates, "/spec/schedulingGates")...)
+	}
+	return patch
+}
+
+// setSchedulingField performs the mutation needed to set a single scalar field
+func setSchedulingField(current string, field *SchedulingField, path string) (patch []patchOperation) {
+	if field == nil || current == field.Value {
+		return patch
+	}
+	if current == "" {
+		// add replaces a member that is only set to its default
+		structuredLog(LogLevelInfo, "Scheduling", "Setting %s to %s", path, field.Value)
+		return append(patch, patchOperation{Op: "add", Path: path, Value: field.Value})
+	}
+	if !field.Force {
+		structuredLog(LogLevelDebug, "Scheduling", "Keeping existing %s %s (not forced)", path, current)
+		return patch
+	}
+	structuredLog(LogLevelInfo, "Scheduling", "Replacing %s %s with %s", path, current, field.Value)
+	return append(patch, patchOperation{Op: "replace", Path: path, Value: field.Value})
+}
+
+// addSchedulingGates performs the mutation(s) needed to add the missing scheduling gates to the
+// target resource
+func addSchedulingGates(target []corev1.PodSchedulingGate, gates *SchedulingGates, basePath string) (patch []patchOperation) {
+	first := len(target) == 0
+	if !first && !gates.Force {
+		structuredLog(LogLevelDebug, "Scheduling", "Keeping existing %d scheduling gates (not forced)", len(target))
+		return patch
+	}
+
+	for _, name := range gates.Names {
+		gate := corev1.PodSchedulingGate{Name: name}
+		exists := false
+		for _, targetGate := range target {
+			if targetGate.Name == name {
+				exists = true
+			}
+		}
+		if exists {
+			structuredLog(LogLevelDebug, "Scheduling", "Skipping existing scheduling gate: %s", name)
+			continue
+		}
+
+		if first {
+			first = false
+			structuredLog(LogLevelInfo, "Scheduling", "Adding first scheduling gate: %s", name)
+			patch = append(patch, patchOperation{Op: "add", Path: basePath, Value: []corev1.PodSchedulingGate{gate}})
+		} else {
+			structuredLog(LogLevelInfo, "Scheduling", "Adding scheduling gate: %s", name)
+			patch = append(patch, patchOperation{Op: "add", Path: jsonPointer(basePath, "-"), Value: gate})
+		}
+	}
+	return patch
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
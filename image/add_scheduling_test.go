@@ -0,0 +1,192 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestAddSchedulingFields(t *testing.T) {
+	// the pods are shown as admitted by the webhook, after API defaulting and the Priority and
+	// RuntimeClass admission plugins
+	tests := []struct {
+		name string
+		pod  string
+		cfg  string
+		want []string // patch operations, "op path"
+	}{
+		{
+			name: "defaulted fields count as unset",
+			pod: `
+spec:
+  schedulerName: default-scheduler
+  priority: 0
+  preemptionPolicy: PreemptLowerPriority
+  containers:
+    - name: app
+      image: nginx
+`,
+			cfg: `
+priorityClassName:
+  value: spot-low
+schedulerName:
+  value: spot-scheduler
+preemptionPolicy:
+  value: Never
+`,
+			want: []string{
+				"add /spec/priorityClassName",
+				"remove /spec/priority",
+				"add /spec/schedulerName",
+				"add /spec/preemptionPolicy",
+			},
+		},
+		{
+			name: "fields set by the pod are kept",
+			pod: `
+spec:
+  priorityClassName: critical
+  priority: 1000000
+  preemptionPolicy: Never
+  schedulerName: batch-scheduler
+  runtimeClassName: gvisor
+  containers:
+    - name: app
+      image: nginx
+`,
+			cfg: `
+priorityClassName:
+  value: spot-low
+schedulerName:
+  value: spot-scheduler
+runtimeClassName:
+  value: kata
+preemptionPolicy:
+  value: PreemptLowerPriority
+`,
+			want: []string{},
+		},
+		{
+			name: "forced priority class recomputed by the admission plugin",
+			pod: `
+spec:
+  priorityClassName: critical
+  priority: 1000000
+  preemptionPolicy: PreemptLowerPriority
+  containers:
+    - name: app
+      image: nginx
+`,
+			cfg: `
+priorityClassName:
+  value: spot-low
+  force: true
+`,
+			want: []string{
+				"replace /spec/priorityClassName",
+				"remove /spec/priority",
+				"remove /spec/preemptionPolicy",
+			},
+		},
+		{
+			name: "forced runtime class recomputed by the admission plugin",
+			pod: `
+spec:
+  runtimeClassName: gvisor
+  overhead:
+    cpu: 250m
+  containers:
+    - name: app
+      image: nginx
+`,
+			cfg: `
+runtimeClassName:
+  value: kata
+  force: true
+`,
+			want: []string{"replace /spec/runtimeClassName", "remove /spec/overhead"},
+		},
+		{
+			name: "scheduling gates",
+			pod: `
+spec:
+  schedulingGates:
+    - name: example.com/quota-check
+  containers:
+    - name: app
+      image: nginx
+`,
+			cfg: `
+schedulingGates:
+  names: [example.com/quota-check, example.com/image-scan]
+  force: true
+`,
+			want: []string{"add /spec/schedulingGates/-"},
+		},
+		{
+			name: "scheduling gates not forced",
+			pod: `
+spec:
+  schedulingGates:
+    - name: example.com/other
+  containers:
+    - name: app
+      image: nginx
+`,
+			cfg: `
+schedulingGates:
+  names: [example.com/quota-check]
+`,
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := testPod(t, tt.pod)
+			cfg := testConfig(t, tt.cfg)
+			patch := addSchedulingFields(&pod.Spec, &cfg)
+			got := []string{}
+			for _, op := range patch {
+				got = append(got, op.Op+" "+op.Path)
+			}
+			if !equalStrings(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if _, err := applyPatch(pod, patch); err != nil {
+				t.Errorf("patch does not apply: %v", err)
+			}
+		})
+	}
+}
+
+func TestAddSchedulingFieldsPod(t *testing.T) {
+	pod := testPod(t, `
+spec:
+  schedulerName: default-scheduler
+  priority: 0
+  preemptionPolicy: PreemptLowerPriority
+  containers:
+    - name: app
+      image: nginx
+`)
+	cfg := testConfig(t, `
+priorityClassName:
+  value: spot-low
+preemptionPolicy:
+  value: Never
+schedulingGates:
+  names: [example.com/quota-check]
+`)
+
+	patched, _ := mustMutateTestPod(t, pod, cfg)
+	if patched.Spec.PriorityClassName != "spot-low" || patched.Spec.Priority != nil {
+		t.Errorf("got priorityClassName %q and priority %v, want spot-low and the priority removed", patched.Spec.PriorityClassName, patched.Spec.Priority)
+	}
+	if patched.Spec.PreemptionPolicy == nil || *patched.Spec.PreemptionPolicy != corev1.PreemptNever {
+		t.Errorf("got preemptionPolicy %v, want Never", patched.Spec.PreemptionPolicy)
+	}
+	if len(patched.Spec.SchedulingGates) != 1 || patched.Spec.SchedulingGates[0].Name != "example.com/quota-check" {
+		t.Errorf("got scheduling gates %v", patched.Spec.SchedulingGates)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// capSysAdmin may not be added to a container that disallows privilege escalation
+const capSysAdmin corev1.Capability = "SYS_ADMIN"
+
+// SecurityContextDefaults brings pods towards the restricted Pod Security Standard by setting
+// the fields the pod and its selected containers leave unset
+type SecurityContextDefaults struct {
+	ContainerTarget `yaml:",inline"`
+
+	// RunAsNonRoot and SeccompProfile are set at pod level, and on containers overriding them
+	RunAsNonRoot   *bool                  `yaml:"runAsNonRoot,omitempty" json:"runAsNonRoot,omitempty"`
+	SeccompProfile *corev1.SeccompProfile `yaml:"seccompProfile,omitempty" json:"seccompProfile,omitempty"`
+	// AllowPrivilegeEscalation, ReadOnlyRootFilesystem and DropCapabilities are set on containers
+	AllowPrivilegeEscalation *bool               `yaml:"allowPrivilegeEscalation,omitempty" json:"allowPrivilegeEscalation,omitempty"`
+	ReadOnlyRootFilesystem   *bool               `yaml:"readOnlyRootFilesystem,omitempty" json:"readOnlyRootFilesystem,omitempty"`
+	DropCapabilities         []corev1.Capability `yaml:"dropCapabilities,omitempty" json:"dropCapabilities,omitempty"`
+
+	// MergeStrategies sets the strategy of each field explicitly set to another value by the
+	// pod. It defaults to mergeStrategies.securityContext, or keepExisting with a warning.
+	MergeStrategies SecurityContextMergeStrategies `yaml:"mergeStrategies,omitempty" json:"mergeStrategies,omitempty"`
+}
+
+// SecurityContextMergeStrategies sets the merge strategy of each security context field
+type SecurityContextMergeStrategies struct {
+	RunAsNonRoot             MergeStrategy `yaml:"runAsNonRoot,omitempty" json:"runAsNonRoot,omitempty"`
+	SeccompProfile           MergeStrategy `yaml:"seccompProfile,omitempty" json:"seccompProfile,omitempty"`
+	AllowPrivilegeEscalation MergeStrategy `yaml:"allowPrivilegeEscalation,omitempty" json:"allowPrivilegeEscalation,omitempty"`
+	ReadOnlyRootFilesystem   MergeStrategy `yaml:"readOnlyRootFilesystem,omitempty" json:"readOnlyRootFilesystem,omitempty"`
+}
+
+// addSecurityContext performs the mutation(s) needed to harden the pod and its selected
+// containers. Ephemeral containers are added after admission of the pod and are not hardened.
+func addSecurityContext(mc *mutationContext, pod *corev1.Pod, envConfig *Config) ([]patchOperation, error) {
+	if envConfig.SecurityContext == nil {
+		return nil, nil
+	}
+
+	p := &securityContextPatcher{mc: mc, defaults: envConfig.SecurityContext, fallback: envConfig.MergeStrategies.SecurityContext}
+	if err := p.addPodSecurityContext(&pod.Spec); err != nil {
+		return nil, err
+	}
+	for _, c := range podContainers(&pod.Spec, ContainerTypeInitContainers, ContainerTypeContainers) {
+		if ok, reason := envConfig.SecurityContext.selects(c, envConfig.ContainerSelector); !ok {
+			structuredLog(LogLevelDebug, "SecurityContext", "Skipping security context for container %s: %s", c.Container.Name, reason)
+			continue
+		}
+		if err := p.addContainerSecurityContext(c); err != nil {
+			return nil, err
+		}
+	}
+	return p.patch, nil
+}
+
+// securityContextPatcher collects the security context patches of a pod
+type securityContextPatcher struct {
+	mc       *mutationContext
+	defaults *SecurityContextDefaults
+	fallback MergeStrategy
+	patch    []patchOperation
+}
+
+// strategy returns the merge strategy of a field
+func (p *securityContextPatcher) strategy(s MergeStrategy) MergeStrategy {
+	return s.or(p.fallback).or(MergeStrategyKeepExisting)
+}
+
+// setField performs the mutation needed to set a single field. A field set to another value
+// is resolved with the strategy; a kept value is reported as an admission warning.
+func (p *securityContextPatcher) setField(path string, current, value interface{}, strategy MergeStrategy) error {
+	if current == nil {
+		structuredLog(LogLevelInfo, "SecurityContext", "Setting %s to %v", path, value)
+		p.patch = append(p.patch, patchOperation{Op: "add", Path: path, Value: value})
+		return nil
+	}
+	if cmp.Equal(current, value) {
+		return nil
+	}
+
+	skip, err := resolveConflict(strategy, path, fmt.Sprint(current))
+	if err != nil {
+		return err
+	}
+	if skip {
+		p.mc.warn("%s is set to %v, kept instead of the hardened value %v", path, current, value)
+		return nil
+	}
+	structuredLog(LogLevelInfo, "SecurityContext", "Replacing %s %v with %v", path, current, value)
+	p.patch = append(p.patch, patchOperation{Op: "replace", Path: path, Value: value})
+	return nil
+}
+
+// addPodSecurityContext performs the mutation(s) needed to set the pod-level fields
+func (p *securityContextPatcher) addPodSecurityContext(spec *corev1.PodSpec) error {
+	sc := p.defaults
+	if sc.RunAsNonRoot == nil && sc.SeccompProfile == nil {
+		return nil
+	}
+
+	if spec.SecurityContext == nil {
+		p.patch = append(p.patch, patchOperation{Op: "add", Path: "/spec/securityContext", Value: corev1.PodSecurityContext{
+			RunAsNonRoot:   sc.RunAsNonRoot,
+			SeccompProfile: sc.SeccompProfile,
+		}})
+		return nil
+	}
+
+	psc := spec.SecurityContext
+	if sc.RunAsNonRoot != nil {
+		if err := p.setField("/spec/securityContext/runAsNonRoot", boolValue(psc.RunAsNonRoot), *sc.RunAsNonRoot, p.strategy(sc.MergeStrategies.RunAsNonRoot)); err != nil {
+			return err
+		}
+		if psc.RunAsUser != nil && *psc.RunAsUser == 0 && *sc.RunAsNonRoot {
+			p.mc.warn("/spec/securityContext/runAsUser is 0, containers without another runAsUser will fail to start with runAsNonRoot")
+		}
+	}
+	if sc.SeccompProfile != nil {
+		if err := p.setField("/spec/securityContext/seccompProfile", seccompValue(psc.SeccompProfile), *sc.SeccompProfile, p.strategy(sc.MergeStrategies.SeccompProfile)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addContainerSecurityContext performs the mutation(s) needed to harden a single container
+func (p *securityContextPatcher) addContainerSecurityContext(c podContainer) error {
+	sc := p.defaults
+	path := jsonPointer(c.Path, "securityContext")
+	csc := c.Container.SecurityContext
+
+	// a privileged container can never meet the restricted Pod Security Standard, and neither
+	// it nor one adding CAP_SYS_ADMIN may disallow privilege escalation
+	allowPrivilegeEscalation := sc.AllowPrivilegeEscalation
+	if csc != nil && csc.Privileged != nil && *csc.Privileged {
+		p.mc.warn("container %s is privileged and cannot be brought up to the restricted Pod Security Standard", c.Container.Name)
+		allowPrivilegeEscalation = nil
+	} else if csc != nil && csc.Capabilities != nil && containsCapability(csc.Capabilities.Add, capSysAdmin) &&
+		allowPrivilegeEscalation != nil && !*allowPrivilegeEscalation {
+		p.mc.warn("container %s adds %s and cannot disallow privilege escalation", c.Container.Name, capSysAdmin)
+		allowPrivilegeEscalation = nil
+	}
+
+	if csc == nil {
+		hardened := corev1.SecurityContext{
+			AllowPrivilegeEscalation: allowPrivilegeEscalation,
+			ReadOnlyRootFilesystem:   sc.ReadOnlyRootFilesystem,
+		}
+		if len(sc.DropCapabilities) > 0 {
+			hardened.Capabilities = &corev1.Capabilities{Drop: sc.DropCapabilities}
+		}
+		if cmp.Equal(hardened, corev1.SecurityContext{}) {
+			return nil
+		}
+		structuredLog(LogLevelInfo, "SecurityContext", "Adding security context to %s container %s", c.Type, c.Container.Name)
+		p.patch = append(p.patch, patchOperation{Op: "add", Path: path, Value: hardened})
+		return nil
+	}
+
+	// container-level values override the pod-level ones, so only contradicting values are fixed
+	if sc.RunAsNonRoot != nil && csc.RunAsNonRoot != nil {
+		if err := p.setField(jsonPointer(path, "runAsNonRoot"), *csc.RunAsNonRoot, *sc.RunAsNonRoot, p.strategy(sc.MergeStrategies.RunAsNonRoot)); err != nil {
+			return err
+		}
+	}
+	if sc.RunAsNonRoot != nil && *sc.RunAsNonRoot && csc.RunAsUser != nil && *csc.RunAsUser == 0 {
+		p.mc.warn("container %s runs as user 0 and will fail to start with runAsNonRoot", c.Container.Name)
+	}
+	if sc.SeccompProfile != nil && csc.SeccompProfile != nil {
+		if err := p.setField(jsonPointer(path, "seccompProfile"), *csc.SeccompProfile, *sc.SeccompProfile, p.strategy(sc.MergeStrategies.SeccompProfile)); err != nil {
+			return err
+		}
+	}
+	if allowPrivilegeEscalation != nil {
+		if err := p.setField(jsonPointer(path, "allowPrivilegeEscalation"), boolValue(csc.AllowPrivilegeEscalation), *allowPrivilegeEscalation, p.strategy(sc.MergeStrategies.AllowPrivilegeEscalation)); err != nil {
+			return err
+		}
+	}
+	if sc.ReadOnlyRootFilesystem != nil {
+		if err := p.setField(jsonPointer(path, "readOnlyRootFilesystem"), boolValue(csc.ReadOnlyRootFilesystem), *sc.ReadOnlyRootFilesystem, p.strategy(sc.MergeStrategies.ReadOnlyRootFilesystem)); err != nil {
+			return err
+		}
+	}
+	p.addDropCapabilities(csc.Capabilities, jsonPointer(path, "capabilities"))
+	return nil
+}
+
+// addDropCapabilities adds the missing capabilities to the drop list. Dropping capabilities
+// never conflicts with the container, so there is no merge strategy.
+func (p *securityContextPatcher) addDropCapabilities(capabilities *corev1.Capabilities, path string) {
+	drop := p.defaults.DropCapabilities
+	if len(drop) == 0 {
+		return
+	}
+	if capabilities == nil {
+		p.patch = append(p.patch, patchOperation{Op: "add", Path: path, Value: corev1.Capabilities{Drop: drop}})
+		return
+	}
+	if capabilities.Drop == nil {
+		p.patch = append(p.patch, patchOperation{Op: "add", Path: jsonPointer(path, "drop"), Value: drop})
+		return
+	}
+	for _, capability := range drop {
+		if !containsCapability(capabilities.Drop, capability) {
+			p.patch = append(p.patch, patchOperation{Op: "add", Path: jsonPointer(path, "drop", "-"), Value: capability})
+		}
+	}
+}
+
+func containsCapability(capabilities []corev1.Capability, capability corev1.Capability) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// boolValue returns the value of b, or nil when it is not set
+func boolValue(b *bool) interface{} {
+	if b == nil {
+		return nil
+	}
+	return *b
+}
+
+// seccompValue returns the value of profile, or nil when it is not set
+func seccompValue(profile *corev1.SeccompProfile) interface{} {
+	if profile == nil {
+		return nil
+	}
+	return *profile
+}
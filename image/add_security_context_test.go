@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// restrictedSecurityContext is a profile bringing pods up to the restricted Pod Security Standard
+const restrictedSecurityContext = `
+securityContext:
+  runAsNonRoot: true
+  seccompProfile:
+    type: RuntimeDefault
+  allowPrivilegeEscalation: false
+  readOnlyRootFilesystem: true
+  dropCapabilities: [ALL]
+`
+
+// securityContextState formats the hardened fields of a security context, unset fields as "-"
+func securityContextState(sc *corev1.SecurityContext) string {
+	if sc == nil {
+		return "nil"
+	}
+	format := func(b *bool) string {
+		if b == nil {
+			return "-"
+		}
+		return fmt.Sprint(*b)
+	}
+	var drop []corev1.Capability
+	if sc.Capabilities != nil {
+		drop = sc.Capabilities.Drop
+	}
+	return fmt.Sprintf("nonRoot=%s escalation=%s readOnly=%s drop=%v",
+		format(sc.RunAsNonRoot), format(sc.AllowPrivilegeEscalation), format(sc.ReadOnlyRootFilesystem), drop)
+}
+
+func TestAddSecurityContext(t *testing.T) {
+	tests := []struct {
+		name       string
+		pod        string
+		config     string
+		podNonRoot bool
+		containers map[string]string
+		warns      int
+		wantErr    string
+	}{
+		{
+			name: "pod without security context",
+			pod: `
+spec:
+  initContainers:
+    - name: migrate
+      image: flyway
+  containers:
+    - name: app
+      image: nginx
+`,
+			config:     restrictedSecurityContext,
+			podNonRoot: true,
+			containers: map[string]string{
+				"migrate": "nil",
+				"app":     "nonRoot=- escalation=false readOnly=true drop=[ALL]",
+			},
+		},
+		{
+			name: "partial security contexts",
+			pod: `
+spec:
+  securityContext:
+    fsGroup: 2000
+  containers:
+    - name: app
+      image: nginx
+      securityContext:
+        runAsUser: 1000
+        capabilities:
+          add: [NET_BIND_SERVICE]
+          drop: [NET_RAW]
+`,
+			config:     restrictedSecurityContext,
+			podNonRoot: true,
+			containers: map[string]string{"app": "nonRoot=- escalation=false readOnly=true drop=[NET_RAW ALL]"},
+		},
+		{
+			name: "explicit values are kept with a warning",
+			pod: `
+spec:
+  securityContext:
+    runAsNonRoot: false
+  containers:
+    - name: app
+      image: nginx
+      securityContext:
+        readOnlyRootFilesystem: false
+`,
+			config:     restrictedSecurityContext,
+			containers: map[string]string{"app": "nonRoot=- escalation=false readOnly=false drop=[ALL]"},
+			warns:      2,
+		},
+		{
+			name: "override strategy",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+      securityContext:
+        runAsNonRoot: false
+        readOnlyRootFilesystem: false
+`,
+			config: restrictedSecurityContext + `
+  mergeStrategies:
+    runAsNonRoot: override
+    readOnlyRootFilesystem: override
+`,
+			podNonRoot: true,
+			containers: map[string]string{"app": "nonRoot=true escalation=false readOnly=true drop=[ALL]"},
+		},
+		{
+			name: "privileged container",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+    - name: cni
+      image: calico/node
+      securityContext:
+        privileged: true
+    - name: fuse
+      image: sshfs
+      securityContext:
+        capabilities:
+          add: [SYS_ADMIN]
+`,
+			config:     restrictedSecurityContext,
+			podNonRoot: true,
+			containers: map[string]string{
+				"app":  "nonRoot=- escalation=false readOnly=true drop=[ALL]",
+				"cni":  "nonRoot=- escalation=- readOnly=true drop=[ALL]",
+				"fuse": "nonRoot=- escalation=- readOnly=true drop=[ALL]",
+			},
+			warns: 2,
+		},
+		{
+			name: "container running as root",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+      securityContext:
+        runAsUser: 0
+`,
+			config:     restrictedSecurityContext,
+			podNonRoot: true,
+			containers: map[string]string{"app": "nonRoot=- escalation=false readOnly=true drop=[ALL]"},
+			warns:      1,
+		},
+		{
+			name: "fail strategy",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+      securityContext:
+        allowPrivilegeEscalation: true
+`,
+			config: restrictedSecurityContext + `
+mergeStrategies:
+  securityContext: fail
+`,
+			wantErr: "/spec/containers/0/securityContext/allowPrivilegeEscalation: existing entry \"true\" conflicts with the injected value (mergeStrategy: fail)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, mc, err := mutateTestPod(testPod(t, tt.pod), testConfig(t, tt.config))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			psc := patched.Spec.SecurityContext
+			nonRoot := psc != nil && psc.RunAsNonRoot != nil && *psc.RunAsNonRoot
+			if nonRoot != tt.podNonRoot {
+				t.Errorf("got pod runAsNonRoot %v, want %v", nonRoot, tt.podNonRoot)
+			}
+			if tt.podNonRoot && (psc.SeccompProfile == nil || psc.SeccompProfile.Type != corev1.SeccompProfileTypeRuntimeDefault) {
+				t.Errorf("got pod seccompProfile %v, want RuntimeDefault", psc.SeccompProfile)
+			}
+			for _, c := range podContainers(&patched.Spec, allContainerTypes...) {
+				if got := securityContextState(c.Container.SecurityContext); got != tt.containers[c.Container.Name] {
+					t.Errorf("container %s: got %s, want %s", c.Container.Name, got, tt.containers[c.Container.Name])
+				}
+			}
+			if len(mc.warnings) != tt.warns {
+				t.Errorf("got warnings %q, want %d", mc.warnings, tt.warns)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Sidecar is a container injected into the pod. Every string field of the container spec may
+// be a Go template expanded against the pod like env var values, e.g.
+// `--service={{ .Labels.app }}`. `.Container` refers to the sidecar itself.
+type Sidecar struct {
+	corev1.Container `yaml:",inline"`
+
+	// NativeSidecar injects the container as an init container with restartPolicy Always,
+	// started before the other init containers and kept running for the lifetime of the pod
+	NativeSidecar bool `yaml:"nativeSidecar,omitempty" json:"nativeSidecar,omitempty"`
+	// MergeStrategy overrides the sidecars block merge strategy for this sidecar
+	MergeStrategy MergeStrategy `yaml:"mergeStrategy,omitempty" json:"mergeStrategy,omitempty"`
+}
+
+// renderSidecar expands the templated string fields of the sidecar spec for the pod
+func renderSidecar(sidecar Sidecar, pod *corev1.Pod) (Sidecar, error) {
+	data := newEnvTemplateData(pod, &sidecar.Container)
+	container, err := walkContainerTemplates(sidecar.Container, func(value string) (string, error) {
+		return executeEnvTemplate(sidecar.Name, value, data)
+	})
+	if err != nil {
+		return Sidecar{}, fmt.Errorf("failed to expand sidecar %s: %w", sidecar.Name, err)
+	}
+
+	rendered := sidecar
+	rendered.Container = container
+	if sidecar.NativeSidecar {
+		always := corev1.ContainerRestartPolicyAlways
+		rendered.RestartPolicy = &always
+	}
+	return rendered, nil
+}
+
+// walkContainerTemplates calls fn for every templated string field of the container and
+// returns the container with the values returned by fn. Templates are expanded field by field
+// so an expanded value can never change the structure of the spec.
+func walkContainerTemplates(container corev1.Container, fn func(string) (string, error)) (corev1.Container, error) {
+	spec, err := jsonValue(container)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+
+	spec, err = walkTemplateValues(spec, fn)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	var walked corev1.Container
+	if err := json.Unmarshal(raw, &walked); err != nil {
+		return corev1.Container{}, err
+	}
+	return walked, nil
+}
+
+// jsonValue converts v to its generic JSON representation
+func jsonValue(v interface{}) (interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func walkTemplateValues(value interface{}, fn func(string) (string, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if !isEnvTemplate(v) {
+			return v, nil
+		}
+		return fn(v)
+	case []interface{}:
+		for i := range v {
+			walked, err := walkTemplateValues(v[i], fn)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = walked
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			walked, err := walkTemplateValues(v[k], fn)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			v[k] = walked
+		}
+	}
+	return value, nil
+}
+
+// addSidecars performs the mutation(s) needed to add the sidecars to the pod. Regular sidecars
+// are appended to the containers, native sidecars are inserted in declaration order before the
+// existing init containers. A sidecar already present with the same spec is skipped so the
+// patch is idempotent when the webhook is reinvoked.
+func addSidecars(mc *mutationContext, spec *corev1.PodSpec, sidecars []Sidecar, strategy MergeStrategy) (patch []patchOperation, err error) {
+	containersFirst := len(spec.Containers) == 0
+	initContainersFirst := len(spec.InitContainers) == 0
+	inserted := 0
+
+	for _, sidecar := range sidecars {
+		target, other, basePath := spec.Containers, spec.InitContainers, "/spec/containers"
+		if sidecar.NativeSidecar {
+			target, other, basePath = spec.InitContainers, spec.Containers, "/spec/initContainers"
+		}
+
+		if containerIndex(other, sidecar.Name) >= 0 {
+			mc.warn("sidecar %s conflicts with an existing container of the same name, it was not injected", sidecar.Name)
+			continue
+		}
+
+		var value interface{} = sidecar.Container
+		path := basePath
+		var skip bool
+		var op string
+		if idx := containerIndex(target, sidecar.Name); idx >= 0 {
+			specEqual := containerIncludes(target[idx], sidecar.Container)
+			if sidecar.NativeSidecar {
+				// existing init containers moved behind the native sidecars inserted so far
+				idx += inserted
+			}
+			skip, op, path, err = checkMergeStrategy(sidecar.MergeStrategy.or(strategy), idx, path, sidecar.Name, specEqual)
+			if err != nil {
+				return nil, err
+			}
+			if !skip {
+				structuredLog(LogLevelInfo, "Sidecars", "Updating existing sidecar container at index %d: %s", idx, sidecar.Name)
+			} else {
+				structuredLog(LogLevelDebug, "Sidecars", "Skipping sidecar container at index %d: %s (already injected or keeping existing value)", idx, sidecar.Name)
+			}
+		} else if sidecar.NativeSidecar && initContainersFirst {
+			initContainersFirst = false
+			op = "add"
+			value = []corev1.Container{sidecar.Container}
+			inserted++
+			structuredLog(LogLevelInfo, "Sidecars", "Adding first init container as native sidecar: %s", sidecar.Name)
+		} else if sidecar.NativeSidecar {
+			op = "add"
+			path = jsonPointer(path, strconv.Itoa(inserted))
+			inserted++
+			structuredLog(LogLevelInfo, "Sidecars", "Adding native sidecar container: %s", sidecar.Name)
+		} else if containersFirst {
+			containersFirst = false
+			op = "add"
+			value = []corev1.Container{sidecar.Container}
+			structuredLog(LogLevelInfo, "Sidecars", "Adding first container as sidecar: %s", sidecar.Name)
+		} else {
+			op = "add"
+			path = jsonPointer(path, "-")
+			structuredLog(LogLevelInfo, "Sidecars", "Adding sidecar container: %s", sidecar.Name)
+		}
+
+		if !skip {
+			patch = append(patch, patchOperation{
+				Op:    op,
+				Path:  path,
+				Value: value,
+			})
+		}
+	}
+	return patch, nil
+}
+
+// containerIndex returns the index of the container with the given name, or -1
+func containerIndex(containers []corev1.Container, name string) int {
+	for idx, container := range containers {
+		if container.Name == name {
+			return idx
+		}
+	}
+	return -1
+}
+
+// containerIncludes reports whether every field set in the injected spec has the same value in
+// the existing container. Fields defaulted by the API server on the existing container, e.g.
+// terminationMessagePath, are ignored so a reinvocation does not replace an injected sidecar.
+func containerIncludes(existing, injected corev1.Container) bool {
+	existingSpec, err := jsonValue(existing)
+	if err != nil {
+		return false
+	}
+	injectedSpec, err := jsonValue(injected)
+	if err != nil {
+		return false
+	}
+	return specIncludes(existingSpec, injectedSpec)
+}
+
+func specIncludes(existing, injected interface{}) bool {
+	switch v := injected.(type) {
+	case map[string]interface{}:
+		m, ok := existing.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, value := range v {
+			if !specIncludes(m[k], value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		l, ok := existing.([]interface{})
+		if !ok || len(l) != len(v) {
+			return false
+		}
+		for i := range v {
+			if !specIncludes(l[i], v[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return cmp.Equal(existing, injected)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// containerNames returns the names of the containers in order
+func containerNames(containers []corev1.Container) []string {
+	names := make([]string, 0, len(containers))
+	for _, container := range containers {
+		names = append(names, container.Name)
+	}
+	return names
+}
+
+func TestAddSidecars(t *testing.T) {
+	pod := `
+metadata:
+  name: checkout-7d4f
+  labels:
+    app: checkout
+spec:
+  initContainers:
+    - name: migrate
+      image: flyway
+  containers:
+    - name: app
+      image: nginx
+`
+
+	tests := []struct {
+		name           string
+		pod            string
+		config         string
+		initContainers []string
+		containers     []string
+		warns          int
+		wantErr        string
+	}{
+		{
+			name: "regular and native sidecars",
+			pod:  pod,
+			config: `
+sidecars:
+  - name: log-shipper
+    image: fluent/fluent-bit:3.1
+  - name: proxy
+    image: envoyproxy/envoy:v1.31
+    nativeSidecar: true
+  - name: vault-agent
+    image: hashicorp/vault:1.17
+    nativeSidecar: true
+`,
+			initContainers: []string{"proxy", "vault-agent", "migrate"},
+			containers:     []string{"app", "log-shipper"},
+		},
+		{
+			name: "first containers of a kind",
+			pod: `
+spec:
+  containers: []
+`,
+			config: `
+sidecars:
+  - name: log-shipper
+    image: fluent/fluent-bit:3.1
+  - name: proxy
+    image: envoyproxy/envoy:v1.31
+    nativeSidecar: true
+`,
+			initContainers: []string{"proxy"},
+			containers:     []string{"log-shipper"},
+		},
+		{
+			name: "opted out",
+			pod: `
+metadata:
+  annotations:
+    env-injector-webhook-inject-sidecars: "false"
+spec:
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+sidecars:
+  - name: log-shipper
+    image: fluent/fluent-bit:3.1
+env:
+  - name: REGION
+    value: westeurope
+`,
+			initContainers: []string{},
+			containers:     []string{"app"},
+		},
+		{
+			name: "name used by a container of the other kind",
+			pod:  pod,
+			config: `
+sidecars:
+  - name: migrate
+    image: flyway
+`,
+			initContainers: []string{"migrate"},
+			containers:     []string{"app"},
+			warns:          1,
+		},
+		{
+			name: "existing sidecar with keepExisting",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+    - name: log-shipper
+      image: fluent/fluent-bit:2.0
+`,
+			config: `
+sidecars:
+  - name: log-shipper
+    image: fluent/fluent-bit:3.1
+mergeStrategies:
+  sidecars: keepExisting
+`,
+			initContainers: []string{},
+			containers:     []string{"app", "log-shipper"},
+		},
+		{
+			name: "existing sidecar with fail",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+    - name: log-shipper
+      image: fluent/fluent-bit:2.0
+`,
+			config: `
+sidecars:
+  - name: log-shipper
+    image: fluent/fluent-bit:3.1
+    mergeStrategy: fail
+`,
+			wantErr: `/spec/containers/1: existing entry "log-shipper" conflicts with the injected value (mergeStrategy: fail)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, mc, err := mutateTestPod(testPod(t, tt.pod), testConfig(t, tt.config))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := containerNames(patched.Spec.InitContainers); !equalStrings(got, tt.initContainers) {
+				t.Errorf("got init containers %v, want %v", got, tt.initContainers)
+			}
+			if got := containerNames(patched.Spec.Containers); !equalStrings(got, tt.containers) {
+				t.Errorf("got containers %v, want %v", got, tt.containers)
+			}
+			for _, container := range patched.Spec.InitContainers {
+				native := container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways
+				if native != (container.Name != "migrate") {
+					t.Errorf("init container %s: native sidecar = %v", container.Name, native)
+				}
+			}
+			if len(mc.warnings) != tt.warns {
+				t.Errorf("got warnings %q, want %d", mc.warnings, tt.warns)
+			}
+		})
+	}
+}
+
+func TestAddSidecarsTemplates(t *testing.T) {
+	pod := testPod(t, `
+metadata:
+  name: checkout-7d4f
+  labels:
+    app: checkout
+spec:
+  containers:
+    - name: app
+      image: nginx
+`)
+	cfg := testConfig(t, `
+sidecars:
+  - name: log-shipper
+    image: fluent/fluent-bit:3.1
+    args:
+      - --service={{ .Labels.app }}
+      - --pod={{ .Name }}
+      - --container={{ .Container.Name }}
+    env:
+      - name: SERVICE
+        value: "{{ .Labels.app }}"
+`)
+
+	patched, _ := mustMutateTestPod(t, pod, cfg)
+	sidecar := patched.Spec.Containers[1]
+	want := []string{"--service=checkout", "--pod=checkout-7d4f", "--container=log-shipper"}
+	if !equalStrings(sidecar.Args, want) {
+		t.Errorf("got args %v, want %v", sidecar.Args, want)
+	}
+	if got := envValues(sidecar)["SERVICE"]; got != "checkout" {
+		t.Errorf("got SERVICE %q, want checkout", got)
+	}
+}
+
+// TestAddSidecarsReinvocation covers a reinvocation after the API server defaulted the fields
+// of the injected sidecars
+func TestAddSidecarsReinvocation(t *testing.T) {
+	pod := testPod(t, `
+spec:
+  containers:
+    - name: app
+      image: nginx
+`)
+	cfg := testConfig(t, `
+sidecars:
+  - name: log-shipper
+    image: fluent/fluent-bit:3.1
+  - name: proxy
+    image: envoyproxy/envoy:v1.31
+    nativeSidecar: true
+`)
+
+	patched, _ := mustMutateTestPod(t, pod, cfg)
+	for _, containers := range [][]corev1.Container{patched.Spec.Containers, patched.Spec.InitContainers} {
+		for i := range containers {
+			containers[i].TerminationMessagePath = corev1.TerminationMessagePathDefault
+			containers[i].ImagePullPolicy = corev1.PullIfNotPresent
+		}
+	}
+
+	patches, err := createPatchOperations(&mutationContext{}, patched.DeepCopy(), []Profile{{Name: "test", Config: cfg}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) > 0 {
+		t.Errorf("got patch %+v on reinvocation, want none", patches)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import "sort"
+
+// addStringMap performs the mutation(s) needed to add the entries to a string map of the target
+// resource, e.g. labels. Keys are escaped, so keys such as example.com/team are patched as a
+// single member.
+func addStringMap(target map[string]string, entries map[string]string, basePath string, strategy MergeStrategy) (patch []patchOperation, err error) {
+	first := len(target) == 0
+	if first {
+		structuredLog(LogLevelDebug, "StringMap", "No existing entries found at %s, will create new map", basePath)
+	} else {
+		structuredLog(LogLevelDebug, "StringMap", "Found %d existing entries at %s", len(target), basePath)
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var value interface{}
+	for _, key := range keys {
+		value = entries[key]
+		path := jsonPointer(basePath, key)
+		var skip bool
+		op := "add"
+		if first {
+			first = false
+			path = basePath
+			value = map[string]string{key: entries[key]}
+			structuredLog(LogLevelDebug, "StringMap", "Adding first entry: %s", key)
+		} else if existing, ok := target[key]; ok {
+			op = "replace"
+			skip = existing == entries[key]
+			if !skip {
+				skip, err = resolveConflict(strategy, path, key)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if !skip {
+				structuredLog(LogLevelInfo, "StringMap", "Updating existing entry: %s", key)
+			} else {
+				structuredLog(LogLevelDebug, "StringMap", "Skipping entry update: %s (no changes needed or keeping existing value)", key)
+			}
+		} else {
+			structuredLog(LogLevelInfo, "StringMap", "Adding new entry: %s", key)
+		}
+		if !skip {
+			patch = append(patch, patchOperation{
+				Op:    op,
+				Path:  path,
+				Value: value,
+			})
+		}
+	}
+	return patch, nil
+}
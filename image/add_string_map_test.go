@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddLabelsAndAnnotations(t *testing.T) {
+	pod := testPod(t, `
+metadata:
+  labels:
+    app: checkout
+    example.com/team: payments
+  annotations:
+    prometheus.io/scrape: "false"
+spec:
+  containers:
+    - name: app
+      image: nginx
+`)
+
+	tests := []struct {
+		name        string
+		pod         string
+		config      string
+		labels      map[string]string
+		annotations map[string]string
+		wantErr     string
+	}{
+		{
+			name: "keys with slashes",
+			config: `
+labels:
+  example.com/team: platform
+  example.com/cost-center: "42"
+annotations:
+  prometheus.io/scrape: "true"
+  prometheus.io/port: "9090"
+`,
+			labels: map[string]string{
+				"app":                     "checkout",
+				"example.com/team":        "platform",
+				"example.com/cost-center": "42",
+			},
+			annotations: map[string]string{"prometheus.io/scrape": "true", "prometheus.io/port": "9090"},
+		},
+		{
+			name: "keepExisting",
+			config: `
+labels:
+  example.com/team: platform
+  tier: backend
+annotations:
+  prometheus.io/scrape: "true"
+mergeStrategies:
+  labels: keepExisting
+  annotations: keepExisting
+`,
+			labels:      map[string]string{"app": "checkout", "example.com/team": "payments", "tier": "backend"},
+			annotations: map[string]string{"prometheus.io/scrape": "false"},
+		},
+		{
+			name: "fail",
+			config: `
+labels:
+  example.com/team: platform
+mergeStrategies:
+  labels: fail
+`,
+			wantErr: `/metadata/labels/example.com~1team: existing entry "example.com/team" conflicts with the injected value (mergeStrategy: fail)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, _, err := mutateTestPod(pod, testConfig(t, tt.config))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalStringMaps(patched.Labels, tt.labels) {
+				t.Errorf("got labels %v, want %v", patched.Labels, tt.labels)
+			}
+			if !equalStringMaps(patched.Annotations, tt.annotations) {
+				t.Errorf("got annotations %v, want %v", patched.Annotations, tt.annotations)
+			}
+		})
+	}
+}
+
+func TestAddStringMapFirstEntry(t *testing.T) {
+	patch, err := addStringMap(nil, map[string]string{"example.com/team": "platform", "app": "checkout"}, "/metadata/labels", MergeStrategyOverride)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"add /metadata/labels", "add /metadata/labels/example.com~1team"}
+	var got []string
+	for _, op := range patch {
+		got = append(got, op.Op+" "+op.Path)
+	}
+	if !equalStrings(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestJSONPointer(t *testing.T) {
+	tests := []struct {
+		tokens []string
+		want   string
+	}{
+		{tokens: []string{"example.com/team"}, want: "/metadata/labels/example.com~1team"},
+		{tokens: []string{"a~b/c"}, want: "/metadata/labels/a~0b~1c"},
+		{tokens: []string{"app", "x"}, want: "/metadata/labels/app/x"},
+	}
+	for _, tt := range tests {
+		if got := jsonPointer("/metadata/labels", tt.tokens...); got != tt.want {
+			t.Errorf("jsonPointer(%q) = %q, want %q", tt.tokens, got, tt.want)
+		}
+	}
+}
+
+// equalStringMaps reports whether two maps hold the same entries
+func equalStringMaps(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
@@ -5,8 +5,15 @@ import (
 	corev1 "k8s.io/api/core/v1"
 )
 
+// Toleration is a toleration to inject
+type Toleration struct {
+	corev1.Toleration `yaml:",inline"`
+
+	MergeStrategy MergeStrategy `yaml:"mergeStrategy,omitempty" json:"mergeStrategy,omitempty"`
+}
+
 // addToleration performs the mutation(s) needed to add the extra tolerations to the target resource
-func addTolerations(target, Tolerations []corev1.Toleration, basePath string) (patch []patchOperation) {
+func addTolerations(target []corev1.Toleration, Tolerations []Toleration, basePath string, strategy MergeStrategy) (patch []patchOperation, err error) {
 	first := len(target) == 0
 	if first {
 		structuredLog(LogLevelDebug, "Tolerations", "No existing tolerations found, will create new array")
@@ -16,14 +23,14 @@ func addTolerations(target, Tolerations []corev1.Toleration, basePath string) (p
 
 	var value interface{}
 	for _, tol := range Tolerations {
-		value = tol
+		value = tol.Toleration
 		path := basePath
 		var skip bool
 		var op string
 		if first {
 			first = false
 			op = "add"
-			value = []corev1.Toleration{tol}
+			value = []corev1.Toleration{tol.Toleration}
 			structuredLog(LogLevelDebug, "Tolerations", "Adding first toleration with key: %s", tol.Key)
 		} else {
 			optExists := false
@@ -35,17 +42,20 @@ func addTolerations(target, Tolerations []corev1.Toleration, basePath string) (p
 					effectEqual := cmp.Equal(targetOpt.Effect, tol.Effect)
 					valueEqual := cmp.Equal(targetOpt.Value, tol.Value)
 
-					skip, op, path = checkReplaceOrSkip(idx, path, operatorEqual, effectEqual, valueEqual)
+					skip, op, path, err = checkMergeStrategy(tol.MergeStrategy.or(strategy), idx, path, tol.Key, operatorEqual, effectEqual, valueEqual)
+					if err != nil {
+						return nil, err
+					}
 					if !skip {
 						structuredLog(LogLevelInfo, "Tolerations", "Updating existing toleration at index %d with key: %s", idx, tol.Key)
 					} else {
-						structuredLog(LogLevelDebug, "Tolerations", "Skipping toleration update at index %d with key: %s (no changes needed)", idx, tol.Key)
+						structuredLog(LogLevelDebug, "Tolerations", "Skipping toleration update at index %d with key: %s (no changes needed or keeping existing value)", idx, tol.Key)
 					}
 				}
 			}
 			if !optExists {
 				op = "add"
-				path = path + "/-"
+				path = jsonPointer(path, "-")
 				structuredLog(LogLevelInfo, "Tolerations", "Adding new toleration with key: %s", tol.Key)
 			}
 		}
@@ -55,9 +65,7 @@ func addTolerations(target, Tolerations []corev1.Toleration, basePath string) (p
 				Path:  path,
 				Value: value,
 			})
-		} else {
-			patch = []patchOperation{}
 		}
 	}
-	return patch
+	return patch, nil
 }
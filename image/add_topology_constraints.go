@@ -5,8 +5,15 @@ import (
 	corev1 "k8s.io/api/core/v1"
 )
 
+// TopologySpreadConstraint is a topology spread constraint to inject
+type TopologySpreadConstraint struct {
+	corev1.TopologySpreadConstraint `yaml:",inline"`
+
+	MergeStrategy MergeStrategy `yaml:"mergeStrategy,omitempty" json:"mergeStrategy,omitempty"`
+}
+
 // addTopologySpreadConstraints performs the mutation(s) needed to add Topology Spread Constraints to your resource
-func addTopologySpreadConstraints(target, TopologyConstraints []corev1.TopologySpreadConstraint, basePath string) (patch []patchOperation) {
+func addTopologySpreadConstraints(target []corev1.TopologySpreadConstraint, TopologyConstraints []TopologySpreadConstraint, basePath string, strategy MergeStrategy) (patch []patchOperation, err error) {
 	first := len(target) == 0
 	if first {
 		structuredLog(LogLevelDebug, "Topology", "No existing topology spread constraints found, will create new array")
@@ -16,14 +23,14 @@ func addTopologySpreadConstraints(target, TopologyConstraints []corev1.TopologyS
 
 	var value interface{}
 	for _, tsc := range TopologyConstraints {
-		value = tsc
+		value = tsc.TopologySpreadConstraint
 		path := basePath
 		var skip bool
 		var op string
 		if first {
 			first = false
 			op = "add"
-			value = []corev1.TopologySpreadConstraint{tsc}
+			value = []corev1.TopologySpreadConstraint{tsc.TopologySpreadConstraint}
 			structuredLog(LogLevelDebug, "Topology", "Adding first topology spread constraint with key: %s", tsc.TopologyKey)
 		} else {
 			optExists := false
@@ -38,17 +45,20 @@ func addTopologySpreadConstraints(target, TopologyConstraints []corev1.TopologyS
 					labelSelectorEqual := cmp.Equal(targetOpt.LabelSelector, tsc.LabelSelector)
 					matchLabelKeysEqual := cmp.Equal(targetOpt.MatchLabelKeys, tsc.MatchLabelKeys)
 
-					skip, op, path = checkReplaceOrSkip(idx, path, skewEqual, nodeAffinityEqual, nodeTaintEqual, unsatisfiableEqual, labelSelectorEqual, matchLabelKeysEqual)
+					skip, op, path, err = checkMergeStrategy(tsc.MergeStrategy.or(strategy), idx, path, tsc.TopologyKey, skewEqual, nodeAffinityEqual, nodeTaintEqual, unsatisfiableEqual, labelSelectorEqual, matchLabelKeysEqual)
+					if err != nil {
+						return nil, err
+					}
 					if !skip {
 						structuredLog(LogLevelInfo, "Topology", "Updating existing topology spread constraint at index %d with key: %s", idx, tsc.TopologyKey)
 					} else {
-						structuredLog(LogLevelDebug, "Topology", "Skipping topology spread constraint update at index %d with key: %s (no changes needed)", idx, tsc.TopologyKey)
+						structuredLog(LogLevelDebug, "Topology", "Skipping topology spread constraint update at index %d with key: %s (no changes needed or keeping existing value)", idx, tsc.TopologyKey)
 					}
 				}
 			}
 			if !optExists {
 				op = "add"
-				path = path + "/-"
+				path = jsonPointer(path, "-")
 				structuredLog(LogLevelInfo, "Topology", "Adding new topology spread constraint with key: %s", tsc.TopologyKey)
 			}
 		}
@@ -58,9 +68,7 @@ func addTopologySpreadConstraints(target, TopologyConstraints []corev1.TopologyS
 				Path:  path,
 				Value: value,
 			})
-		} else {
-			patch = []patchOperation{}
 		}
 	}
-	return patch
+	return patch, nil
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Volume is a pod volume to inject
+type Volume struct {
+	corev1.Volume `yaml:",inline"`
+
+	MergeStrategy MergeStrategy `yaml:"mergeStrategy,omitempty" json:"mergeStrategy,omitempty"`
+}
+
+// VolumeMount is a volume mount to inject into the selected containers
+type VolumeMount struct {
+	corev1.VolumeMount `yaml:",inline"`
+	ContainerTarget    `yaml:",inline"`
+
+	MergeStrategy MergeStrategy `yaml:"mergeStrategy,omitempty" json:"mergeStrategy,omitempty"`
+}
+
+// addVolumes performs the mutation(s) needed to add the extra volumes to the target resource
+func addVolumes(target []corev1.Volume, volumes []Volume, basePath string, strategy MergeStrategy) (patch []patchOperation, err error) {
+	first := len(target) == 0
+	if first {
+		structuredLog(LogLevelDebug, "Volumes", "No existing volumes found, will create new array")
+	} else {
+		structuredLog(LogLevelDebug, "Volumes", "Found %d existing volumes", len(target))
+	}
+
+	var value interface{}
+	for _, volume := range volumes {
+		value = volume.Volume
+		path := basePath
+		var skip bool
+		var op string
+		if first {
+			first = false
+			op = "add"
+			value = []corev1.Volume{volume.Volume}
+			structuredLog(LogLevelDebug, "Volumes", "Adding first volume: %s", volume.Name)
+		} else {
+			volumeExists := false
+			for idx, targetVolume := range target {
+				if targetVolume.Name == volume.Name {
+					volumeExists = true
+					sourceEqual := cmp.Equal(targetVolume.VolumeSource, volume.VolumeSource)
+
+					skip, op, path, err = checkMergeStrategy(volume.MergeStrategy.or(strategy), idx, path, volume.Name, sourceEqual)
+					if err != nil {
+						return nil, err
+					}
+					if !skip {
+						structuredLog(LogLevelInfo, "Volumes", "Updating existing volume at index %d: %s", idx, volume.Name)
+					} else {
+						structuredLog(LogLevelDebug, "Volumes", "Skipping volume update at index %d: %s (no changes needed or keeping existing value)", idx, volume.Name)
+					}
+				}
+			}
+			if !volumeExists {
+				op = "add"
+				path = jsonPointer(path, "-")
+				structuredLog(LogLevelInfo, "Volumes", "Adding new volume: %s", volume.Name)
+			}
+		}
+		if !skip {
+			patch = append(patch, patchOperation{
+				Op:    op,
+				Path:  path,
+				Value: value,
+			})
+		}
+	}
+	return patch, nil
+}
+
+// addVolumeMounts performs the mutation(s) needed to add the extra volume mounts to the target
+// container. Mounts are identified by volume name and mount path. A mount whose path is already
+// used by a mount of another volume, existing or injected before it, would make the pod
+// invalid, so it is skipped with an admission warning, or rejected with the fail merge strategy.
+func addVolumeMounts(mc *mutationContext, target []corev1.VolumeMount, mounts []VolumeMount, basePath string, strategy MergeStrategy) (patch []patchOperation, err error) {
+	first := len(target) == 0
+	if first {
+		structuredLog(LogLevelDebug, "VolumeMounts", "No existing volume mounts found, will create new array")
+	} else {
+		structuredLog(LogLevelDebug, "VolumeMounts", "Found %d existing volume mounts", len(target))
+	}
+
+	// the injected mounts are added to a copy of the target so later mounts are checked against them
+	target = append([]corev1.VolumeMount(nil), target...)
+	var value interface{}
+	for _, mount := range mounts {
+		value = mount.VolumeMount
+		path := basePath
+		var skip bool
+		var op string
+		if first {
+			first = false
+			op = "add"
+			value = []corev1.VolumeMount{mount.VolumeMount}
+			structuredLog(LogLevelDebug, "VolumeMounts", "Adding first volume mount: %s at %s", mount.Name, mount.MountPath)
+		} else {
+			mountExists := false
+			for idx, targetMount := range target {
+				if targetMount.MountPath != mount.MountPath {
+					continue
+				}
+				mountExists = true
+				if targetMount.Name != mount.Name {
+					mergeStrategy := mount.MergeStrategy.or(strategy)
+					msg := fmt.Sprintf("%s: mount path %s of volume %s is already used by volume %s", jsonPointer(basePath, strconv.Itoa(idx)), mount.MountPath, mount.Name, targetMount.Name)
+					if mergeStrategy == MergeStrategyFail {
+						return nil, fmt.Errorf("%s (mergeStrategy: %s)", msg, mergeStrategy)
+					}
+					mc.warn("%s, the volume mount was not injected", msg)
+					skip = true
+					continue
+				}
+
+				subPathEqual := targetMount.SubPath == mount.SubPath && targetMount.SubPathExpr == mount.SubPathExpr
+				readOnlyEqual := targetMount.ReadOnly == mount.ReadOnly
+				propagationEqual := cmp.Equal(targetMount.MountPropagation, mount.MountPropagation)
+
+				skip, op, path, err = checkMergeStrategy(mount.MergeStrategy.or(strategy), idx, path, mount.Name+":"+mount.MountPath, subPathEqual, readOnlyEqual, propagationEqual)
+				if err != nil {
+					return nil, err
+				}
+				if !skip {
+					structuredLog(LogLevelInfo, "VolumeMounts", "Updating existing volume mount at index %d: %s at %s", idx, mount.Name, mount.MountPath)
+				} else {
+					structuredLog(LogLevelDebug, "VolumeMounts", "Skipping volume mount update at index %d: %s at %s (no changes needed or keeping existing value)", idx, mount.Name, mount.MountPath)
+				}
+			}
+			if !mountExists {
+				op = "add"
+				path = jsonPointer(path, "-")
+				structuredLog(LogLevelInfo, "VolumeMounts", "Adding new volume mount: %s at %s", mount.Name, mount.MountPath)
+			}
+		}
+		if !skip {
+			if op == "add" {
+				target = append(target, mount.VolumeMount)
+			}
+			patch = append(patch, patchOperation{
+				Op:    op,
+				Path:  path,
+				Value: value,
+			})
+		}
+	}
+	return patch, nil
+}
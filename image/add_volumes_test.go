@@ -0,0 +1,194 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// mountPaths returns the mounts of a container as "volume:mountPath"
+func mountPaths(container corev1.Container) []string {
+	paths := make([]string, 0, len(container.VolumeMounts))
+	for _, mount := range container.VolumeMounts {
+		paths = append(paths, mount.Name+":"+mount.MountPath)
+	}
+	return paths
+}
+
+func TestAddVolumeMounts(t *testing.T) {
+	tests := []struct {
+		name    string
+		pod     string
+		config  string
+		volumes []string
+		mounts  []string
+		warns   int
+		wantErr string
+	}{
+		{
+			name: "volume and mount",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+volumes:
+  - name: custom-ca
+    configMap:
+      name: custom-ca
+volumeMounts:
+  - name: custom-ca
+    mountPath: /etc/ssl/custom
+    readOnly: true
+`,
+			volumes: []string{"custom-ca"},
+			mounts:  []string{"custom-ca:/etc/ssl/custom"},
+		},
+		{
+			name: "mount of existing volume",
+			pod: `
+spec:
+  volumes:
+    - name: data
+      emptyDir: {}
+  containers:
+    - name: app
+      image: nginx
+      volumeMounts:
+        - name: data
+          mountPath: /data
+`,
+			config: `
+volumeMounts:
+  - name: data
+    mountPath: /data
+  - name: data
+    mountPath: /backup
+    subPath: backup
+`,
+			volumes: []string{"data"},
+			mounts:  []string{"data:/data", "data:/backup"},
+		},
+		{
+			name: "mount of missing volume",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+`,
+			config: `
+volumeMounts:
+  - name: missing
+    mountPath: /missing
+`,
+			volumes: []string{},
+			mounts:  []string{},
+			warns:   1,
+		},
+		{
+			name: "mount path used by another volume",
+			pod: `
+spec:
+  volumes:
+    - name: certs
+      emptyDir: {}
+  containers:
+    - name: app
+      image: nginx
+      volumeMounts:
+        - name: certs
+          mountPath: /etc/ssl/custom
+`,
+			config: `
+volumes:
+  - name: custom-ca
+    configMap:
+      name: custom-ca
+volumeMounts:
+  - name: custom-ca
+    mountPath: /etc/ssl/custom
+`,
+			volumes: []string{"certs", "custom-ca"},
+			mounts:  []string{"certs:/etc/ssl/custom"},
+			warns:   1,
+		},
+		{
+			name: "mount path used by another volume with fail",
+			pod: `
+spec:
+  volumes:
+    - name: certs
+      emptyDir: {}
+  containers:
+    - name: app
+      image: nginx
+      volumeMounts:
+        - name: certs
+          mountPath: /etc/ssl/custom
+`,
+			config: `
+volumes:
+  - name: custom-ca
+    configMap:
+      name: custom-ca
+volumeMounts:
+  - name: custom-ca
+    mountPath: /etc/ssl/custom
+    mergeStrategy: fail
+`,
+			wantErr: "/spec/containers/0/volumeMounts/0: mount path /etc/ssl/custom of volume custom-ca is already used by volume certs",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, mc, err := mutateTestPod(testPod(t, tt.pod), testConfig(t, tt.config))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			volumes := []string{}
+			for _, volume := range patched.Spec.Volumes {
+				volumes = append(volumes, volume.Name)
+			}
+			if !equalStrings(volumes, tt.volumes) {
+				t.Errorf("got volumes %v, want %v", volumes, tt.volumes)
+			}
+			if got := mountPaths(patched.Spec.Containers[0]); !equalStrings(got, tt.mounts) {
+				t.Errorf("got mounts %v, want %v", got, tt.mounts)
+			}
+			if len(mc.warnings) != tt.warns {
+				t.Errorf("got warnings %q, want %d", mc.warnings, tt.warns)
+			}
+		})
+	}
+}
+
+// TestAddVolumeMountsSamePath covers mounts injected in one call, which are checked against
+// each other as well as against the existing mounts
+func TestAddVolumeMountsSamePath(t *testing.T) {
+	mounts := []VolumeMount{
+		{VolumeMount: corev1.VolumeMount{Name: "a", MountPath: "/etc/app"}},
+		{VolumeMount: corev1.VolumeMount{Name: "b", MountPath: "/etc/app"}},
+	}
+	for _, target := range [][]corev1.VolumeMount{nil, {{Name: "data", MountPath: "/data"}}} {
+		mc := &mutationContext{}
+		patch, err := addVolumeMounts(mc, target, mounts, "/spec/containers/0/volumeMounts", MergeStrategyOverride)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(patch) != 1 || len(mc.warnings) != 1 {
+			t.Errorf("existing mounts %v: got patch %+v and warnings %q, want the second mount skipped", target, patch, mc.warnings)
+		}
+	}
+}
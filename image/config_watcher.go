@@ -0,0 +1,97 @@
+package main
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/google/go-cmp/cmp"
+	"sigs.k8s.io/yaml"
+)
+
+// configReloadDelay debounces the burst of events produced by a ConfigMap update, which
+// swaps the `..data` symlink inside the mounted directory rather than writing the file
+const configReloadDelay = 500 * time.Millisecond
+
+// watchConfig watches the directory holding the configuration file and reloads it on change.
+// The last good configuration stays active when the new file cannot be loaded.
+func (whsvr *WebhookServer) watchConfig(configFile string, stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// watch the parent directory: the file itself is a symlink that is replaced on update
+	if err := watcher.Add(filepath.Dir(configFile)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		var reload <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				structuredLog(LogLevelDebug, "Config", "配置目录事件: %s", event)
+				reload = time.After(configReloadDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				structuredLog(LogLevelWarning, "Config", "配置文件监听错误: %v", err)
+			case <-reload:
+				reload = nil
+				whsvr.reloadConfig(configFile)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	structuredLog(LogLevelInfo, "Config", "开始监听配置文件 %s", configFile)
+	return nil
+}
+
+// reloadConfig loads the configuration file and atomically swaps it in when its content changed
+func (whsvr *WebhookServer) reloadConfig(configFile string) {
+	newConfig, err := loadConfig(configFile)
+	if err != nil {
+		structuredLog(LogLevelError, "Config", "重新加载配置文件失败，继续使用当前配置: %v", err)
+		return
+	}
+
+	oldConfig := whsvr.envConfig.Load()
+	if oldConfig != nil && oldConfig.checksum == newConfig.checksum {
+		structuredLog(LogLevelDebug, "Config", "配置文件内容未变化，跳过重新加载")
+		return
+	}
+
+	whsvr.envConfig.Store(newConfig)
+	if whsvr.objects == nil {
+		warnUncheckedReferences(newConfig)
+	}
+	if oldConfig != nil {
+		structuredLog(LogLevelInfo, "Config", "配置已更新 sha256sum %x -> %x, 差异:\n%s",
+			oldConfig.checksum, newConfig.checksum, configDiff(oldConfig, newConfig))
+	} else {
+		structuredLog(LogLevelInfo, "Config", "配置已加载 sha256sum %x", newConfig.checksum)
+	}
+}
+
+// configDiff renders a line diff of the two configurations. The profiles are compared in their
+// YAML form because several Kubernetes types (e.g. resource.Quantity) cannot be compared by cmp.
+func configDiff(oldConfig, newConfig *EnvConfig) string {
+	oldYAML, err := yaml.Marshal(oldConfig.Profiles)
+	if err != nil {
+		return err.Error()
+	}
+	newYAML, err := yaml.Marshal(newConfig.Profiles)
+	if err != nil {
+		return err.Error()
+	}
+	return cmp.Diff(string(oldYAML), string(newYAML))
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeConfigMap lays out the configuration like a mounted ConfigMap: the file is a symlink
+// through the `..data` symlink to a timestamped directory. Writing again swaps `..data`.
+func writeConfigMap(t *testing.T, dir, version, content string) string {
+	t.Helper()
+	dataDir := filepath.Join(dir, ".."+version)
+	if err := os.Mkdir(dataDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "envconfig.yaml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Base(dataDir), filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "envconfig.yaml")
+	if _, err := os.Lstat(configFile); os.IsNotExist(err) {
+		if err := os.Symlink(filepath.Join("..data", "envconfig.yaml"), configFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return configFile
+}
+
+// activeRegion returns the value of the REGION env var of the active configuration
+func activeRegion(whsvr *WebhookServer) string {
+	for _, envVar := range whsvr.activeConfig().Profiles[0].Env {
+		if envVar.Name == "REGION" {
+			return envVar.Value
+		}
+	}
+	return ""
+}
+
+func regionConfig(region string) string {
+	return `
+env:
+  - name: REGION
+    value: ` + region + `
+  - name: SPOT_REGION
+    value: ` + region + `
+`
+}
+
+func TestReloadConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "envconfig.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	whsvr := &WebhookServer{}
+	write(regionConfig("westeurope"))
+	whsvr.reloadConfig(configFile)
+	loaded := whsvr.envConfig.Load()
+	if got := activeRegion(whsvr); got != "westeurope" {
+		t.Fatalf("got REGION %q, want westeurope", got)
+	}
+
+	whsvr.reloadConfig(configFile)
+	if whsvr.envConfig.Load() != loaded {
+		t.Errorf("unchanged configuration was swapped")
+	}
+
+	for name, content := range map[string]string{
+		"parse error":      "env: [",
+		"unnamed profile":  "profiles:\n  - env: []\n",
+		"unknown field":    "envs: []",
+		"validation error": "env:\n  - name: 1REGION\n    value: northeurope\n",
+	} {
+		write(content)
+		whsvr.reloadConfig(configFile)
+		if whsvr.envConfig.Load() != loaded {
+			t.Errorf("%s: last good configuration was replaced", name)
+		}
+	}
+
+	write(regionConfig("northeurope"))
+	whsvr.reloadConfig(configFile)
+	if got := activeRegion(whsvr); got != "northeurope" {
+		t.Errorf("got REGION %q, want northeurope", got)
+	}
+}
+
+func TestWatchConfigSymlinkSwap(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "config")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	configFile := writeConfigMap(t, dir, "v1", regionConfig("westeurope"))
+
+	whsvr := &WebhookServer{}
+	whsvr.reloadConfig(configFile)
+	stop := make(chan struct{})
+	defer close(stop)
+	if err := whsvr.watchConfig(configFile, stop); err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfigMap(t, dir, "v2", regionConfig("northeurope"))
+	deadline := time.Now().Add(5 * time.Second)
+	for activeRegion(whsvr) != "northeurope" {
+		if time.Now().After(deadline) {
+			t.Fatalf("configuration not reloaded after the ..data swap, REGION is %q", activeRegion(whsvr))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// TestReloadConfigConcurrent checks that in-flight requests always see a complete configuration
+func TestReloadConfigConcurrent(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "envconfig.yaml")
+	whsvr := &WebhookServer{}
+	if err := os.WriteFile(configFile, []byte(regionConfig("westeurope")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	whsvr.reloadConfig(configFile)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				profile := whsvr.activeConfig().Profiles[0]
+				if profile.Env[0].Value != profile.Env[1].Value {
+					t.Errorf("got a partial configuration: REGION %q, SPOT_REGION %q", profile.Env[0].Value, profile.Env[1].Value)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		region := []string{"westeurope", "northeurope"}[i%2]
+		if err := os.WriteFile(configFile, []byte(regionConfig(region)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		whsvr.reloadConfig(configFile)
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestConfigDiff(t *testing.T) {
+	dir := t.TempDir()
+	load := func(name, content string) *EnvConfig {
+		t.Helper()
+		configFile := filepath.Join(dir, name)
+		if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		envConfig, err := loadConfig(configFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return envConfig
+	}
+	oldConfig := load("old.yaml", regionConfig("westeurope"))
+	newConfig := load("new.yaml", regionConfig("northeurope")+"removePodAntiAffinity: true\n")
+
+	diff := configDiff(oldConfig, newConfig)
+	for _, want := range []string{"value: northeurope", "removePodAntiAffinity: true"} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("diff does not contain %q:\n%s", want, diff)
+		}
+	}
+	if strings.Contains(diff, "RemovePodAntiAffinity") {
+		t.Errorf("diff uses Go field names:\n%s", diff)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// imageRegexPrefix marks an image pattern as a regular expression instead of a glob
+const imageRegexPrefix = "regex:"
+
+// ContainerSelector selects the containers of a pod a rule applies to. All the set
+// criteria must match; an empty selector matches every container.
+type ContainerSelector struct {
+	// IncludeNames restricts the rule to containers with one of these names
+	IncludeNames []string `yaml:"includeNames,omitempty" json:"includeNames,omitempty"`
+	// ExcludeNames skips containers with one of these names, e.g. istio-proxy
+	ExcludeNames []string `yaml:"excludeNames,omitempty" json:"excludeNames,omitempty"`
+	// IncludeImages restricts the rule to containers whose image matches one of the patterns.
+	// Patterns are globs where `*` matches any sequence of characters, or regular expressions
+	// when prefixed with "regex:". Both must match the whole image.
+	IncludeImages []string `yaml:"includeImages,omitempty" json:"includeImages,omitempty"`
+	// ExcludeImages skips containers whose image matches one of the patterns
+	ExcludeImages []string `yaml:"excludeImages,omitempty" json:"excludeImages,omitempty"`
+	// PortName restricts the rule to containers declaring a port with this name
+	PortName string `yaml:"portName,omitempty" json:"portName,omitempty"`
+
+	// the image patterns, compiled when the config is validated
+	includeImages []*regexp.Regexp
+	excludeImages []*regexp.Regexp
+}
+
+// matches reports whether the container is selected, with the reason when it is not
+func (cs *ContainerSelector) matches(container *corev1.Container) (bool, string) {
+	if cs == nil {
+		return true, ""
+	}
+
+	if len(cs.IncludeNames) > 0 && !containsString(cs.IncludeNames, container.Name) {
+		return false, "name not in includeNames"
+	}
+	if containsString(cs.ExcludeNames, container.Name) {
+		return false, "name in excludeNames"
+	}
+	if len(cs.IncludeImages) > 0 && !matchesAnyImage(cs.includeImages, container.Image) {
+		return false, fmt.Sprintf("image %s does not match includeImages", container.Image)
+	}
+	if matchesAnyImage(cs.excludeImages, container.Image) {
+		return false, fmt.Sprintf("image %s matches excludeImages", container.Image)
+	}
+	if cs.PortName != "" {
+		found := false
+		for _, port := range container.Ports {
+			if port.Name == cs.PortName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, fmt.Sprintf("no port named %s", cs.PortName)
+		}
+	}
+	return true, ""
+}
+
+// compileImagePattern turns a glob or "regex:" pattern into an anchored regular expression
+func compileImagePattern(pattern string) (*regexp.Regexp, error) {
+	if expr, ok := strings.CutPrefix(pattern, imageRegexPrefix); ok {
+		return regexp.Compile("^(?:" + expr + ")$")
+	}
+
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	return regexp.Compile("^" + expr + "$")
+}
+
+func matchesAnyImage(patterns []*regexp.Regexp, image string) bool {
+	for _, re := range patterns {
+		if re.MatchString(image) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
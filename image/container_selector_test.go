@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestContainerSelector(t *testing.T) {
+	pod := testPod(t, `
+spec:
+  containers:
+    - name: app
+      image: registry.example.com/shop/checkout:1.4
+      ports:
+        - name: http
+          containerPort: 8080
+    - name: istio-proxy
+      image: docker.io/istio/proxyv2:1.24.0
+    - name: worker
+      image: registry.example.com/shop/worker:1.4
+`)
+
+	tests := []struct {
+		name   string
+		config string
+		want   []string // containers receiving JAVA_TOOL_OPTIONS
+	}{
+		{
+			name: "exclude names",
+			config: `
+containerSelector:
+  excludeNames: [istio-proxy]
+env:
+  - name: JAVA_TOOL_OPTIONS
+    value: -XX:+UseG1GC
+`,
+			want: []string{"app", "worker"},
+		},
+		{
+			name: "image regex matches the whole image",
+			config: `
+containerSelector:
+  includeImages: ["regex:worker", "regex:.*/checkout:[0-9.]+"]
+env:
+  - name: JAVA_TOOL_OPTIONS
+    value: -XX:+UseG1GC
+`,
+			want: []string{"app"},
+		},
+		{
+			name: "include names",
+			config: `
+containerSelector:
+  includeNames: [worker]
+env:
+  - name: JAVA_TOOL_OPTIONS
+    value: -XX:+UseG1GC
+`,
+			want: []string{"worker"},
+		},
+		{
+			name: "image glob",
+			config: `
+containerSelector:
+  includeImages: ["registry.example.com/shop/*"]
+  excludeImages: ["*/worker:*"]
+env:
+  - name: JAVA_TOOL_OPTIONS
+    value: -XX:+UseG1GC
+`,
+			want: []string{"app"},
+		},
+		{
+			name: "image regex",
+			config: `
+containerSelector:
+  includeImages: ["regex:.*/istio/proxyv2:1\\.2[0-9]\\..*"]
+env:
+  - name: JAVA_TOOL_OPTIONS
+    value: -XX:+UseG1GC
+`,
+			want: []string{"istio-proxy"},
+		},
+		{
+			name: "port name",
+			config: `
+containerSelector:
+  portName: http
+env:
+  - name: JAVA_TOOL_OPTIONS
+    value: -XX:+UseG1GC
+`,
+			want: []string{"app"},
+		},
+		{
+			name: "rule selector overrides profile selector",
+			config: `
+containerSelector:
+  portName: http
+env:
+  - name: JAVA_TOOL_OPTIONS
+    value: -XX:+UseG1GC
+    containerSelector:
+      includeNames: [worker]
+`,
+			want: []string{"worker"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, _ := mustMutateTestPod(t, pod, testConfig(t, tt.config))
+
+			var got []string
+			for _, container := range patched.Spec.Containers {
+				if _, ok := envValues(container)["JAVA_TOOL_OPTIONS"]; ok {
+					got = append(got, container.Name)
+				}
+			}
+			if !equalStrings(got, tt.want) {
+				t.Errorf("injected into %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileImagePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		image   string
+		want    bool
+	}{
+		{pattern: "nginx:*", image: "nginx:1.27", want: true},
+		{pattern: "nginx:*", image: "docker.io/library/nginx:1.27", want: false},
+		{pattern: "*/nginx:1.2?", image: "docker.io/library/nginx:1.27", want: true},
+		{pattern: "registry.example.com/*", image: "registry.example.com.evil/app", want: false},
+		{pattern: "regex:quay\\.io/.*", image: "quay.io/prometheus/node-exporter", want: true},
+		{pattern: "regex:nginx", image: "evil/nginx-proxy", want: false},
+		{pattern: "regex:nginx|.*/nginx", image: "docker.io/library/nginx", want: true},
+		{pattern: "regex:nginx|.*/nginx", image: "docker.io/library/nginx-proxy", want: false},
+	}
+	for _, tt := range tests {
+		re, err := compileImagePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.pattern, err)
+		}
+		if got := re.MatchString(tt.image); got != tt.want {
+			t.Errorf("pattern %s matches %s = %v, want %v", tt.pattern, tt.image, got, tt.want)
+		}
+	}
+}
+
+// equalStrings reports whether two lists hold the same strings in the same order
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ContainerType names one of the container lists of a pod spec
+type ContainerType string
+
+const (
+	ContainerTypeContainers ContainerType = "containers"
+	// init containers, including native sidecars declared with restartPolicy Always
+	ContainerTypeInitContainers      ContainerType = "initContainers"
+	ContainerTypeEphemeralContainers ContainerType = "ephemeralContainers"
+)
+
+var (
+	// defaultContainerTypes is used by rules that do not select container types explicitly
+	defaultContainerTypes = []ContainerType{ContainerTypeContainers}
+	// allContainerTypes lists every container type in pod spec order
+	allContainerTypes = []ContainerType{ContainerTypeInitContainers, ContainerTypeContainers, ContainerTypeEphemeralContainers}
+)
+
+// podContainer is a container of the pod together with the JSON pointer to it
+type podContainer struct {
+	Type      ContainerType
+	Path      string
+	Container *corev1.Container
+}
+
+// podContainers returns the containers of the given types, in pod spec order
+func podContainers(spec *corev1.PodSpec, types ...ContainerType) []podContainer {
+	var containers []podContainer
+	for _, containerType := range types {
+		switch containerType {
+		case ContainerTypeContainers:
+			for idx := range spec.Containers {
+				containers = append(containers, podContainer{Type: containerType,
+					Path: jsonPointer("/spec/containers", strconv.Itoa(idx)), Container: &spec.Containers[idx]})
+			}
+		case ContainerTypeInitContainers:
+			for idx := range spec.InitContainers {
+				containers = append(containers, podContainer{Type: containerType,
+					Path: jsonPointer("/spec/initContainers", strconv.Itoa(idx)), Container: &spec.InitContainers[idx]})
+			}
+		case ContainerTypeEphemeralContainers:
+			for idx := range spec.EphemeralContainers {
+				// EphemeralContainerCommon has the same fields as Container
+				containers = append(containers, podContainer{Type: containerType,
+					Path:      jsonPointer("/spec/ephemeralContainers", strconv.Itoa(idx)),
+					Container: (*corev1.Container)(&spec.EphemeralContainers[idx].EphemeralContainerCommon)})
+			}
+		}
+	}
+	return containers
+}
+
+// isNativeSidecar reports whether an init container keeps running alongside the main containers
+func isNativeSidecar(container *corev1.Container) bool {
+	return container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways
+}
+
+// ContainerTarget selects the containers of the pod a rule is injected into
+type ContainerTarget struct {
+	// ContainerTypes selects the container lists, defaults to containers
+	ContainerTypes []ContainerType `yaml:"containerTypes,omitempty" json:"containerTypes,omitempty"`
+	// ContainerSelector restricts the containers, defaults to the profile containerSelector
+	ContainerSelector *ContainerSelector `yaml:"containerSelector,omitempty" json:"containerSelector,omitempty"`
+}
+
+// selects reports whether the container is targeted, with the reason when it is not
+func (ct *ContainerTarget) selects(c podContainer, defaultSelector *ContainerSelector) (bool, string) {
+	types := ct.ContainerTypes
+	if len(types) == 0 {
+		types = defaultContainerTypes
+	}
+	if !containsContainerType(types, c.Type) {
+		return false, fmt.Sprintf("%s not in containerTypes", c.Type)
+	}
+
+	selector := ct.ContainerSelector
+	if selector == nil {
+		selector = defaultSelector
+	}
+	return selector.matches(c.Container)
+}
+
+// envForContainer returns the env rules that apply to the container
+func envForContainer(envVars []EnvVar, c podContainer, defaultSelector *ContainerSelector) []EnvVar {
+	var selected []EnvVar
+	for _, envVar := range envVars {
+		if ok, reason := envVar.selects(c, defaultSelector); !ok {
+			structuredLog(LogLevelDebug, "EnvVars", "Skipping environment variable %s for container %s: %s", envVar.Name, c.Container.Name, reason)
+			continue
+		}
+		selected = append(selected, envVar)
+	}
+	return selected
+}
+
+func containsContainerType(types []ContainerType, containerType ContainerType) bool {
+	for _, t := range types {
+		if t == containerType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestContainerTypes(t *testing.T) {
+	pod := testPod(t, `
+spec:
+  initContainers:
+    - name: migrate
+      image: flyway
+    - name: proxy
+      image: envoy
+      restartPolicy: Always
+  containers:
+    - name: app
+      image: nginx
+  ephemeralContainers:
+    - name: debugger
+      image: busybox
+`)
+
+	tests := []struct {
+		name   string
+		config string
+		want   map[string]bool // whether REGION is injected, by container name
+	}{
+		{
+			name: "default containers only",
+			config: `
+env:
+  - name: REGION
+    value: westeurope
+`,
+			want: map[string]bool{"migrate": false, "proxy": false, "app": true, "debugger": false},
+		},
+		{
+			name: "init containers and native sidecars",
+			config: `
+env:
+  - name: REGION
+    value: westeurope
+    containerTypes: [initContainers, containers]
+`,
+			want: map[string]bool{"migrate": true, "proxy": true, "app": true, "debugger": false},
+		},
+		{
+			name: "ephemeral containers",
+			config: `
+env:
+  - name: REGION
+    value: westeurope
+    containerTypes: [ephemeralContainers]
+`,
+			want: map[string]bool{"migrate": false, "proxy": false, "app": false, "debugger": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, _ := mustMutateTestPod(t, pod, testConfig(t, tt.config))
+			for _, c := range podContainers(&patched.Spec, allContainerTypes...) {
+				_, injected := envValues(*c.Container)["REGION"]
+				if injected != tt.want[c.Container.Name] {
+					t.Errorf("%s container %s: injected = %v, want %v", c.Type, c.Container.Name, injected, tt.want[c.Container.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestCreateEphemeralPatch(t *testing.T) {
+	oldPod := testPod(t, `
+spec:
+  containers:
+    - name: app
+      image: nginx
+  ephemeralContainers:
+    - name: debugger-1
+      image: busybox
+`)
+	pod := oldPod.DeepCopy()
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, pod.Spec.EphemeralContainers[0])
+	pod.Spec.EphemeralContainers[1].Name = "debugger-2"
+
+	cfg := testConfig(t, `
+env:
+  - name: REGION
+    value: westeurope
+    containerTypes: [containers, ephemeralContainers]
+`)
+	patch, err := createEphemeralPatch(&mutationContext{}, pod, oldPod, []Profile{{Name: "test", Config: cfg}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := applyJSONPatch(pod, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// existing ephemeral containers and the other containers are immutable
+	want := map[string]bool{"app": false, "debugger-1": false, "debugger-2": true}
+	for _, c := range podContainers(&patched.Spec, allContainerTypes...) {
+		_, injected := envValues(*c.Container)["REGION"]
+		if injected != want[c.Container.Name] {
+			t.Errorf("%s container %s: injected = %v, want %v", c.Type, c.Container.Name, injected, want[c.Container.Name])
+		}
+	}
+}
@@ -3,44 +3,551 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
 	corev1 "k8s.io/api/core/v1"
 )
 
-// createPatch creates a mutation patch for resources
-func createPatch(pod *corev1.Pod, envConfig *Config, annotations map[string]string) ([]byte, error) {
+// createPatch creates a mutation patch for resources. Profiles are applied in order, each one
+// computed against the pod as left by the previous profiles, so later profiles win on conflicts.
+func createPatch(mc *mutationContext, pod *corev1.Pod, profiles []Profile, annotations map[string]string) ([]byte, error) {
+	patches, err := createPatchOperations(mc, pod, profiles, annotations)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(patches)
+}
+
+// createPatchOperations returns the operations of the patch created by createPatch, with paths
+// relative to the pod so they can be rebased onto a pod template
+func createPatchOperations(mc *mutationContext, pod *corev1.Pod, profiles []Profile, annotations map[string]string) ([]patchOperation, error) {
 	var patches []patchOperation
 
-	for idx, container := range pod.Spec.Containers {
-		patches = append(patches, addEnv(container.Env, envConfig.Env, fmt.Sprintf("/spec/containers/%d/env", idx))...)
+	for _, profile := range profiles {
+		profilePatches, err := createProfilePatch(mc, pod, &profile.Config)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profile.Name, err)
+		}
+		structuredLog(LogLevelInfo, "Patch", "配置档案 %s 生成 %d 个补丁操作", profile.Name, len(profilePatches))
+		if len(profilePatches) == 0 {
+			continue
+		}
+
+		patched, err := applyPatch(pod, profilePatches)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profile.Name, err)
+		}
+		pod = patched
+		patches = append(patches, profilePatches...)
 	}
-	if len(envConfig.DnsOptions) > 0 {
+
+	if status, ok := annotations[admissionWebhookAnnotationStatusKey]; ok && len(mc.resources) > 0 {
+		annotations[admissionWebhookAnnotationStatusKey] = fmt.Sprintf("%s; resources=%s", status, strings.Join(mc.resources, ","))
+	}
+	patches = append(patches, updateAnnotation(pod.Annotations, annotations)...)
+
+	return patches, nil
+}
+
+// applyPatch returns a copy of the pod with the patch operations applied
+func applyPatch(pod *corev1.Pod, patches []patchOperation) (*corev1.Pod, error) {
+	podBytes, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	patchBytes, err := json.Marshal(patches)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := jsonpatch.DecodePatch(patchBytes)
+	if err != nil {
+		return nil, err
+	}
+	patchedBytes, err := decoded.Apply(podBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply patch: %w", err)
+	}
+
+	var patched corev1.Pod
+	if err := json.Unmarshal(patchedBytes, &patched); err != nil {
+		return nil, err
+	}
+	return &patched, nil
+}
+
+// createEphemeralPatch creates the patch for a request to the pods/ephemeralcontainers
+// subresource. Only env vars, envFrom sources and images of the ephemeral containers added by the request are patched,
+// as every other field of the pod, including existing ephemeral containers, is immutable.
+func createEphemeralPatch(mc *mutationContext, pod, oldPod *corev1.Pod, profiles []Profile) ([]byte, error) {
+	existing := map[string]bool{}
+	for _, container := range oldPod.Spec.EphemeralContainers {
+		existing[container.Name] = true
+	}
+
+	var patches []patchOperation
+	for _, profile := range profiles {
+		profilePatches, err := addContainerEnv(pod, &profile.Config, []ContainerType{ContainerTypeEphemeralContainers}, existing)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profile.Name, err)
+		}
+		envFromPatches, err := addContainerEnvFrom(mc, pod, &profile.Config, []ContainerType{ContainerTypeEphemeralContainers}, existing)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profile.Name, err)
+		}
+		profilePatches = append(profilePatches, envFromPatches...)
+		if profile.Config.ImageRewrite != nil {
+			// the subresource ignores metadata changes, so the original images are not recorded
+			imagePatches, _ := addImageRewrite(mc, pod, profile.Config.ImageRewrite, []ContainerType{ContainerTypeEphemeralContainers}, existing)
+			profilePatches = append(profilePatches, imagePatches...)
+		}
+		if len(profilePatches) == 0 {
+			continue
+		}
+
+		patched, err := applyPatch(pod, profilePatches)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profile.Name, err)
+		}
+		pod = patched
+		patches = append(patches, profilePatches...)
+	}
+
+	return json.Marshal(patches)
+}
+
+// createUpdatePatch creates the patch for an update of a pod. The spec of a running pod is
+// immutable except for a few fields, so only labels, annotations and new tolerations are patched.
+// Images are mutable but not rewritten, as that restarts the containers. Existing tolerations
+// may not be changed and are always kept, and so are existing labels and annotations, which
+// controllers and users change on running pods, e.g. to take a pod out of a service.
+func createUpdatePatch(pod *corev1.Pod, profiles []Profile) ([]byte, error) {
+	patches := []patchOperation{}
+	for _, profile := range profiles {
+		profilePatches, err := createUpdateProfilePatch(pod, &profile.Config)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profile.Name, err)
+		}
+		structuredLog(LogLevelInfo, "Patch", "配置档案 %s 为 Pod 更新生成 %d 个补丁操作", profile.Name, len(profilePatches))
+		if len(profilePatches) == 0 {
+			continue
+		}
+
+		patched, err := applyPatch(pod, profilePatches)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profile.Name, err)
+		}
+		pod = patched
+		patches = append(patches, profilePatches...)
+	}
+
+	return json.Marshal(patches)
+}
+
+// createUpdateProfilePatch creates the update patch for a single profile
+func createUpdateProfilePatch(pod *corev1.Pod, envConfig *Config) ([]patchOperation, error) {
+	var patches []patchOperation
+	if len(envConfig.Labels) > 0 {
+		blockPatches, err := addStringMap(pod.Labels, envConfig.Labels, "/metadata/labels", MergeStrategyKeepExisting)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, blockPatches...)
+	}
+	if len(envConfig.Annotations) > 0 {
+		blockPatches, err := addStringMap(pod.Annotations, envConfig.Annotations, "/metadata/annotations", MergeStrategyKeepExisting)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, blockPatches...)
+	}
+	if len(envConfig.Tolerations) > 0 {
+		tolerations := make([]Toleration, 0, len(envConfig.Tolerations))
+		for _, toleration := range envConfig.Tolerations {
+			toleration.MergeStrategy = MergeStrategyKeepExisting
+			tolerations = append(tolerations, toleration)
+		}
+		blockPatches, err := addTolerations(pod.Spec.Tolerations, tolerations, "/spec/tolerations", MergeStrategyKeepExisting)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, blockPatches...)
+	}
+	return patches, nil
+}
+
+// addContainerEnv injects the env rules into every selected container of the given types,
+// skipping the containers named in skip
+func addContainerEnv(pod *corev1.Pod, envConfig *Config, types []ContainerType, skip map[string]bool) ([]patchOperation, error) {
+	var patches []patchOperation
+	if len(envConfig.Env) == 0 {
+		return patches, nil
+	}
+
+	for _, c := range podContainers(&pod.Spec, types...) {
+		if skip[c.Container.Name] {
+			continue
+		}
+		rules := envForContainer(envConfig.Env, c, envConfig.ContainerSelector)
+		if len(rules) == 0 {
+			structuredLog(LogLevelInfo, "EnvVars", "No environment variables selected for %s container %s", c.Type, c.Container.Name)
+			continue
+		}
+		structuredLog(LogLevelInfo, "EnvVars", "Selected %d of %d environment variables for %s container %s", len(rules), len(envConfig.Env), c.Type, c.Container.Name)
+		if c.Type == ContainerTypeInitContainers && isNativeSidecar(c.Container) {
+			structuredLog(LogLevelDebug, "EnvVars", "Injecting environment variables into native sidecar container %s", c.Container.Name)
+		}
+
+		rendered, err := renderEnv(rules, pod, c.Container)
+		if err != nil {
+			return nil, err
+		}
+		envPatches, err := addEnv(c.Container.Env, rendered, jsonPointer(c.Path, "env"), envConfig.MergeStrategies.Env)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, envPatches...)
+	}
+	return patches, nil
+}
+
+// addContainerEnvFrom injects the envFrom sources into every selected container of the given
+// types, skipping the containers named in skip
+func addContainerEnvFrom(mc *mutationContext, pod *corev1.Pod, envConfig *Config, types []ContainerType, skip map[string]bool) ([]patchOperation, error) {
+	var patches []patchOperation
+	if len(envConfig.EnvFrom) == 0 {
+		return patches, nil
+	}
+
+	sources, err := availableEnvFrom(mc, pod.Namespace, envConfig.EnvFrom)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range podContainers(&pod.Spec, types...) {
+		if skip[c.Container.Name] {
+			continue
+		}
+		var selected []EnvFromSource
+		for _, source := range sources {
+			if ok, reason := source.selects(c, envConfig.ContainerSelector); !ok {
+				structuredLog(LogLevelDebug, "EnvFrom", "Skipping envFrom source %s for container %s: %s", envFromKey(source.EnvFromSource), c.Container.Name, reason)
+				continue
+			}
+			selected = append(selected, source)
+		}
+		if len(selected) == 0 {
+			continue
+		}
+
+		envFromPatches, err := addEnvFrom(c.Container.EnvFrom, selected, jsonPointer(c.Path, "envFrom"), envConfig.MergeStrategies.EnvFrom)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, envFromPatches...)
+	}
+	return patches, nil
+}
+
+// availableEnvFrom drops the sources marked requireExists whose referenced object does not
+// exist in the namespace, with an admission warning
+func availableEnvFrom(mc *mutationContext, namespace string, sources []EnvFromSource) ([]EnvFromSource, error) {
+	available := make([]EnvFromSource, 0, len(sources))
+	for _, source := range sources {
+		if source.RequireExists {
+			kind, name := envFromRef(source.EnvFromSource)
+			gvr := configMapGVR
+			if kind == "Secret" {
+				gvr = secretGVR
+			}
+			exists, err := mc.referenceExists(gvr, namespace, name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to look up %s %s/%s: %w", kind, namespace, name, err)
+			}
+			if !exists {
+				mc.warn("envFrom %s %s/%s does not exist, it was not injected", kind, namespace, name)
+				continue
+			}
+		}
+		available = append(available, source)
+	}
+	return available, nil
+}
+
+// addContainerVolumeMounts injects the volume mounts into every selected container. Mounts of
+// volumes that are neither in the pod nor injected by the profile are skipped with a warning.
+func addContainerVolumeMounts(mc *mutationContext, pod *corev1.Pod, envConfig *Config) ([]patchOperation, error) {
+	var patches []patchOperation
+	if len(envConfig.VolumeMounts) == 0 {
+		return patches, nil
+	}
+
+	volumes := map[string]bool{}
+	for _, volume := range pod.Spec.Volumes {
+		volumes[volume.Name] = true
+	}
+	for _, volume := range envConfig.Volumes {
+		volumes[volume.Name] = true
+	}
+	mounts := make([]VolumeMount, 0, len(envConfig.VolumeMounts))
+	for _, mount := range envConfig.VolumeMounts {
+		if !volumes[mount.Name] {
+			mc.warn("volume %s of the mount at %s does not exist in the pod, the volume mount was not injected", mount.Name, mount.MountPath)
+			continue
+		}
+		mounts = append(mounts, mount)
+	}
+
+	for _, c := range podContainers(&pod.Spec, allContainerTypes...) {
+		var selected []VolumeMount
+		for _, mount := range mounts {
+			if ok, reason := mount.selects(c, envConfig.ContainerSelector); !ok {
+				structuredLog(LogLevelDebug, "VolumeMounts", "Skipping volume mount %s for container %s: %s", mount.Name, c.Container.Name, reason)
+				continue
+			}
+			selected = append(selected, mount)
+		}
+		if len(selected) == 0 {
+			continue
+		}
+
+		mountPatches, err := addVolumeMounts(mc, c.Container.VolumeMounts, selected, jsonPointer(c.Path, "volumeMounts"), envConfig.MergeStrategies.VolumeMounts)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, mountPatches...)
+	}
+	return patches, nil
+}
+
+// addContainerResources sets the default requests and limits of the selected containers.
+// Ephemeral containers may not have resources.
+func addContainerResources(mc *mutationContext, pod *corev1.Pod, envConfig *Config) []patchOperation {
+	var patches []patchOperation
+	if envConfig.Resources == nil {
+		return patches
+	}
+
+	for _, c := range podContainers(&pod.Spec, ContainerTypeInitContainers, ContainerTypeContainers) {
+		if ok, reason := envConfig.Resources.selects(c, envConfig.ContainerSelector); !ok {
+			structuredLog(LogLevelDebug, "Resources", "Skipping resource defaults for container %s: %s", c.Container.Name, reason)
+			continue
+		}
+		resources, changed := envConfig.Resources.containerResources(mc, c.Container)
+		if len(changed) == 0 {
+			continue
+		}
+		patches = append(patches, addResources(c.Container, resources, jsonPointer(c.Path, "resources"))...)
+		mc.resources = append(mc.resources, resourceSummary(c.Container.Name, changed))
+	}
+	return patches
+}
+
+// addPodSidecars renders and injects the sidecars unless the pod opted out of sidecar injection
+func addPodSidecars(mc *mutationContext, pod *corev1.Pod, envConfig *Config) ([]patchOperation, error) {
+	var patches []patchOperation
+	if len(envConfig.Sidecars) == 0 || !sidecarInjectionAllowed(&pod.ObjectMeta) {
+		return patches, nil
+	}
+
+	sidecars := make([]Sidecar, 0, len(envConfig.Sidecars))
+	for _, sidecar := range envConfig.Sidecars {
+		rendered, err := renderSidecar(sidecar, pod)
+		if err != nil {
+			return nil, err
+		}
+		sidecars = append(sidecars, rendered)
+	}
+	return addSidecars(mc, &pod.Spec, sidecars, envConfig.MergeStrategies.Sidecars)
+}
+
+// addPodImageRewrite rewrites the images of every container and records the original images in
+// annotations. An annotation left by a previous invocation is kept, as it holds the image the
+// pod was created with.
+func addPodImageRewrite(mc *mutationContext, pod *corev1.Pod, rw *ImageRewrite) ([]patchOperation, error) {
+	patches, originals := addImageRewrite(mc, pod, rw, allContainerTypes, nil)
+	if len(originals) == 0 {
+		return patches, nil
+	}
+	annotationPatches, err := addStringMap(pod.Annotations, originals, "/metadata/annotations", MergeStrategyKeepExisting)
+	if err != nil {
+		return nil, err
+	}
+	return append(patches, annotationPatches...), nil
+}
+
+// createProfilePatch creates the mutation patch for a single profile
+func createProfilePatch(mc *mutationContext, pod *corev1.Pod, envConfig *Config) ([]patchOperation, error) {
+	// removals are applied first so the other blocks inject into the remaining items, and their
+	// index-based operations are computed against them
+	var patches []patchOperation
+	var err error
+	if envConfig.Remove != nil {
+		if patches = removeItems(pod, envConfig); len(patches) > 0 {
+			if pod, err = applyPatch(pod, patches); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// sidecars are injected next so the other blocks, e.g. env and imageRewrite, also apply to
+	// them
+	sidecarPatches, err := addPodSidecars(mc, pod, envConfig)
+	if err != nil {
+		return nil, err
+	}
+	if len(sidecarPatches) > 0 {
+		if pod, err = applyPatch(pod, sidecarPatches); err != nil {
+			return nil, err
+		}
+		patches = append(patches, sidecarPatches...)
+	}
+
+	// images are rewritten before the annotations block is merged so the annotations recording
+	// the original images are in place
+	if envConfig.ImageRewrite != nil {
+		rewritePatches, err := addPodImageRewrite(mc, pod, envConfig.ImageRewrite)
+		if err != nil {
+			return nil, err
+		}
+		if len(rewritePatches) > 0 {
+			if pod, err = applyPatch(pod, rewritePatches); err != nil {
+				return nil, err
+			}
+			patches = append(patches, rewritePatches...)
+		}
+	}
+
+	if len(envConfig.ImagePullSecrets) > 0 {
+		secrets, err := availableImagePullSecrets(mc, pod.Namespace, envConfig.ImagePullSecrets)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, addImagePullSecrets(pod.Spec.ImagePullSecrets, secrets, "/spec/imagePullSecrets")...)
+	}
+	if len(envConfig.Labels) > 0 {
+		blockPatches, err := addStringMap(pod.Labels, envConfig.Labels, "/metadata/labels", envConfig.MergeStrategies.Labels)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, blockPatches...)
+	}
+	if len(envConfig.Annotations) > 0 {
+		blockPatches, err := addStringMap(pod.Annotations, envConfig.Annotations, "/metadata/annotations", envConfig.MergeStrategies.Annotations)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, blockPatches...)
+	}
+	envPatches, err := addContainerEnv(pod, envConfig, allContainerTypes, nil)
+	if err != nil {
+		return nil, err
+	}
+	patches = append(patches, envPatches...)
+	envFromPatches, err := addContainerEnvFrom(mc, pod, envConfig, allContainerTypes, nil)
+	if err != nil {
+		return nil, err
+	}
+	patches = append(patches, envFromPatches...)
+	if len(envConfig.Volumes) > 0 {
+		blockPatches, err := addVolumes(pod.Spec.Volumes, envConfig.Volumes, "/spec/volumes", envConfig.MergeStrategies.Volumes)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, blockPatches...)
+	}
+	mountPatches, err := addContainerVolumeMounts(mc, pod, envConfig)
+	if err != nil {
+		return nil, err
+	}
+	patches = append(patches, mountPatches...)
+	patches = append(patches, addContainerResources(mc, pod, envConfig)...)
+	securityPatches, err := addSecurityContext(mc, pod, envConfig)
+	if err != nil {
+		return nil, err
+	}
+	patches = append(patches, securityPatches...)
+	if len(envConfig.DnsOptions) > 0 || envConfig.DnsNameservers != nil || envConfig.DnsSearches != nil {
 		if pod.Spec.DNSConfig == nil {
 			pod.Spec.DNSConfig = &corev1.PodDNSConfig{}
 			patches = append(patches, patchOperation{Op: "add", Path: "/spec/dnsConfig", Value: corev1.PodDNSConfig{}})
 		}
-		patches = append(patches, addDnsOptions(pod.Spec.DNSConfig.Options, envConfig.DnsOptions, fmt.Sprintf("/spec/dnsConfig/options"))...)
+	}
+	if envConfig.DnsNameservers != nil {
+		patches = append(patches, addDNSList(mc, pod.Spec.DNSConfig.Nameservers, envConfig.DnsNameservers, "/spec/dnsConfig/nameservers", dnsNameserversLimit)...)
+	}
+	if envConfig.DnsSearches != nil {
+		patches = append(patches, addDNSList(mc, pod.Spec.DNSConfig.Searches, envConfig.DnsSearches, "/spec/dnsConfig/searches", dnsSearchesLimit)...)
+	}
+	if envConfig.DnsPolicy != nil {
+		patches = append(patches, addDNSPolicy(&pod.Spec, envConfig.DnsPolicy)...)
+	}
+	if len(envConfig.DnsOptions) > 0 {
+		blockPatches, err := addDnsOptions(pod.Spec.DNSConfig.Options, envConfig.DnsOptions, "/spec/dnsConfig/options", envConfig.MergeStrategies.DnsOptions)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, blockPatches...)
+	}
+	if len(envConfig.HostAliases) > 0 {
+		blockPatches, err := addHostAliases(pod.Spec.HostAliases, envConfig.HostAliases, "/spec/hostAliases", envConfig.MergeStrategies.HostAliases)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, blockPatches...)
 	}
 	if len(envConfig.Tolerations) > 0 {
 		if pod.Spec.Tolerations == nil {
 			pod.Spec.Tolerations = []corev1.Toleration{}
 			patches = append(patches, patchOperation{Op: "add", Path: "/spec/tolerations", Value: []corev1.Toleration{}})
 		}
-		patches = append(patches, addTolerations(pod.Spec.Tolerations, envConfig.Tolerations, fmt.Sprintf("/spec/tolerations"))...)
+		blockPatches, err := addTolerations(pod.Spec.Tolerations, envConfig.Tolerations, "/spec/tolerations", envConfig.MergeStrategies.Tolerations)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, blockPatches...)
 	}
 	if len(envConfig.TopologyConstraints) > 0 {
 		if pod.Spec.TopologySpreadConstraints == nil {
 			pod.Spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{}
 			patches = append(patches, patchOperation{Op: "add", Path: "/spec/topologySpreadConstraints", Value: []corev1.TopologySpreadConstraint{}})
 		}
-		patches = append(patches, addTopologySpreadConstraints(pod.Spec.TopologySpreadConstraints, envConfig.TopologyConstraints, fmt.Sprintf("/spec/topologySpreadConstraints"))...)
+		blockPatches, err := addTopologySpreadConstraints(pod.Spec.TopologySpreadConstraints, envConfig.TopologyConstraints, "/spec/topologySpreadConstraints", envConfig.MergeStrategies.TopologyConstraints)
+		if err != nil {
+			return nil, err
+		}
+		if len(blockPatches) > 0 && envConfig.PodAntiAffinity != nil {
+			// the constraints converted from pod anti-affinity terms are checked against the
+			// injected ones
+			if pod, err = applyPatch(pod, blockPatches); err != nil {
+				return nil, err
+			}
+		}
+		patches = append(patches, blockPatches...)
 	}
-	if envConfig.RemovePodAntiAffinity {
+	patches = append(patches, addSchedulingFields(&pod.Spec, envConfig)...)
+	if envConfig.RemovePodAntiAffinity || envConfig.PodAntiAffinity != nil {
 		if pod.Spec.Affinity != nil && pod.Spec.Affinity.PodAntiAffinity != nil {
-			// Remove PodAntiAffinity
-			patches = append(patches, removePodAntiAffinity("/spec/affinity/podAntiAffinity")...)
+			rewrite := envConfig.PodAntiAffinity
+			if rewrite == nil {
+				// removePodAntiAffinity predates the podAntiAffinity block
+				rewrite = &PodAntiAffinityRewrite{Mode: PodAntiAffinityModeRemove}
+			}
+			rewritePatches := rewritePodAntiAffinity(mc, &pod.Spec, rewrite, "/spec/affinity/podAntiAffinity")
+			if len(rewritePatches) > 0 && envConfig.PodAntiAffinityTerms != nil {
+				// the injected terms are added to the rewritten anti-affinity
+				if pod, err = applyPatch(pod, rewritePatches); err != nil {
+					return nil, err
+				}
+			}
+			patches = append(patches, rewritePatches...)
 		}
 	}
+	podAffinityPatches, err := addPodAffinity(mc, pod, envConfig)
+	if err != nil {
+		return nil, err
+	}
+	patches = append(patches, podAffinityPatches...)
 	if len(envConfig.RequiredNodeAffinityTerms) > 0 {
 		if pod.Spec.Affinity == nil {
 			pod.Spec.Affinity = &corev1.Affinity{}
@@ -55,7 +562,7 @@ func createPatch(pod *corev1.Pod, envConfig *Config, annotations map[string]stri
 			patches = append(patches, patchOperation{Op: "add", Path: "/spec/affinity/nodeAffinity/requiredDuringSchedulingIgnoredDuringExecution", Value: corev1.NodeSelector{}})
 		}
 		patches = append(patches, addRequiredNodeAffinityTerms(pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms,
-			envConfig.RequiredNodeAffinityTerms, fmt.Sprintf("/spec/affinity/nodeAffinity/requiredDuringSchedulingIgnoredDuringExecution/nodeSelectorTerms"))...)
+			envConfig.RequiredNodeAffinityTerms, "/spec/affinity/nodeAffinity/requiredDuringSchedulingIgnoredDuringExecution/nodeSelectorTerms")...)
 	}
 	if len(envConfig.PreferredNodeAffinityTerms) > 0 {
 		if pod.Spec.Affinity == nil {
@@ -73,8 +580,13 @@ func createPatch(pod *corev1.Pod, envConfig *Config, annotations map[string]stri
 		patches = append(patches, addPreferredNodeAffinityTerms(pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
 			envConfig.PreferredNodeAffinityTerms, "/spec/affinity/nodeAffinity/preferredDuringSchedulingIgnoredDuringExecution")...)
 	}
+	if len(envConfig.NodeSelector) > 0 {
+		blockPatches, err := addNodeSelector(mc, &pod.Spec, envConfig)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, blockPatches...)
+	}
 
-	patches = append(patches, updateAnnotation(pod.Annotations, annotations)...)
-
-	return json.Marshal(patches)
+	return patches, nil
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+)
+
+// testPod decodes a pod manifest
+func testPod(t *testing.T, manifest string) *corev1.Pod {
+	t.Helper()
+	var pod corev1.Pod
+	if err := yaml.UnmarshalStrict([]byte(manifest), &pod); err != nil {
+		t.Fatalf("invalid test pod: %v", err)
+	}
+	return &pod
+}
+
+// testConfig decodes and validates a profile the same way as the configuration file
+func testConfig(t *testing.T, profile string) Config {
+	t.Helper()
+	var cfg Config
+	if errs := decodeConfig([]byte(profile), &cfg); len(errs) > 0 {
+		t.Fatalf("invalid test config: %v", errs)
+	}
+	if errs := validateConfig(&cfg, field.NewPath("profile")); len(errs) > 0 {
+		t.Fatalf("invalid test config: %v", errs.ToAggregate())
+	}
+	return cfg
+}
+
+// mutateTestPod creates the patch for the profiles and applies it to a copy of the pod
+func mutateTestPod(pod *corev1.Pod, configs ...Config) (*corev1.Pod, *mutationContext, error) {
+	mc := &mutationContext{}
+	patched, err := mutateTestPodContext(mc, pod, configs...)
+	return patched, mc, err
+}
+
+// mutateTestPodContext is mutateTestPod with a given mutation context, e.g. with reference checks
+func mutateTestPodContext(mc *mutationContext, pod *corev1.Pod, configs ...Config) (*corev1.Pod, error) {
+	profiles := make([]Profile, 0, len(configs))
+	for _, cfg := range configs {
+		profiles = append(profiles, Profile{Name: "test", Config: cfg})
+	}
+	patches, err := createPatchOperations(mc, pod.DeepCopy(), profiles, nil)
+	if err != nil {
+		return nil, err
+	}
+	return applyPatch(pod, patches)
+}
+
+// mustMutateTestPod is mutateTestPod for mutations that are expected to succeed
+func mustMutateTestPod(t *testing.T, pod *corev1.Pod, configs ...Config) (*corev1.Pod, *mutationContext) {
+	t.Helper()
+	patched, mc, err := mutateTestPod(pod, configs...)
+	if err != nil {
+		t.Fatalf("mutation failed: %v", err)
+	}
+	return patched, mc
+}
+
+// applyJSONPatch applies a marshaled patch, as returned in the admission response, to a copy
+// of the pod
+func applyJSONPatch(pod *corev1.Pod, patch []byte) (*corev1.Pod, error) {
+	var patches []patchOperation
+	if err := json.Unmarshal(patch, &patches); err != nil {
+		return nil, err
+	}
+	return applyPatch(pod, patches)
+}
+
+// envValues returns the env vars of a container by name
+func envValues(container corev1.Container) map[string]string {
+	values := map[string]string{}
+	for _, env := range container.Env {
+		values[env.Name] = env.Value
+	}
+	return values
+}
+
+func TestCreatePatchProfileOrder(t *testing.T) {
+	pod := testPod(t, `
+metadata:
+  name: web
+spec:
+  containers:
+    - name: app
+      image: nginx
+`)
+	first := testConfig(t, `
+env:
+  - name: REGION
+    value: westeurope
+  - name: TIER
+    value: first
+`)
+	second := testConfig(t, `
+env:
+  - name: TIER
+    value: second
+`)
+
+	patched, _ := mustMutateTestPod(t, pod, first, second)
+	want := map[string]string{"REGION": "westeurope", "TIER": "second"}
+	got := envValues(patched.Spec.Containers[0])
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("env %s = %q, want %q", name, got[name], value)
+		}
+	}
+	if len(patched.Spec.Containers[0].Env) != len(want) {
+		t.Errorf("got env %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCreateUpdatePatch(t *testing.T) {
+	pod := testPod(t, `
+metadata:
+  labels:
+    app: checkout
+    example.com/team: payments
+  annotations:
+    example.com/owner: payments
+spec:
+  containers:
+    - name: app
+      image: nginx
+  tolerations:
+    - key: spot
+      operator: Equal
+      value: "false"
+      effect: NoSchedule
+`)
+
+	tests := []struct {
+		name        string
+		config      string
+		labels      map[string]string
+		annotations map[string]string
+		tolerations int
+	}{
+		{
+			name: "nothing to patch",
+			config: `
+env:
+  - name: REGION
+    value: westeurope
+imageRewrite:
+  rules:
+    - prefix: docker.io/
+      replacement: mirror.local/dockerhub/
+`,
+			labels:      map[string]string{"app": "checkout", "example.com/team": "payments"},
+			annotations: map[string]string{"example.com/owner": "payments"},
+			tolerations: 1,
+		},
+		{
+			name: "existing labels, annotations and tolerations are kept",
+			config: `
+labels:
+  example.com/team: platform
+  tier: web
+annotations:
+  example.com/owner: platform
+  example.com/cost-center: shop
+tolerations:
+  - key: spot
+    operator: Equal
+    value: "true"
+    effect: NoSchedule
+  - key: gpu
+    operator: Exists
+mergeStrategies:
+  labels: override
+  annotations: override
+  tolerations: override
+`,
+			labels:      map[string]string{"app": "checkout", "example.com/team": "payments", "tier": "web"},
+			annotations: map[string]string{"example.com/owner": "payments", "example.com/cost-center": "shop"},
+			tolerations: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := createUpdatePatch(pod.DeepCopy(), []Profile{{Name: "test", Config: testConfig(t, tt.config)}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(patch) == "null" {
+				t.Fatalf("got patch null, want a JSON patch")
+			}
+			patched, err := applyJSONPatch(pod, patch)
+			if err != nil {
+				t.Fatalf("patch %s does not apply: %v", patch, err)
+			}
+			if !equalStringMaps(patched.Labels, tt.labels) {
+				t.Errorf("got labels %v, want %v", patched.Labels, tt.labels)
+			}
+			if !equalStringMaps(patched.Annotations, tt.annotations) {
+				t.Errorf("got annotations %v, want %v", patched.Annotations, tt.annotations)
+			}
+			if len(patched.Spec.Tolerations) != tt.tolerations || patched.Spec.Tolerations[0].Value != "false" {
+				t.Errorf("got tolerations %+v, want the existing one kept and %d in total", patched.Spec.Tolerations, tt.tolerations)
+			}
+			if patched.Spec.Containers[0].Image != "nginx" || len(patched.Spec.Containers[0].Env) != 0 {
+				t.Errorf("got container %+v, want it unchanged", patched.Spec.Containers[0])
+			}
+		})
+	}
+}
+
+func TestCreateUpdatePatchEmpty(t *testing.T) {
+	pod := testPod(t, `
+spec:
+  containers:
+    - name: app
+      image: nginx
+`)
+	patch, err := createUpdatePatch(pod, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(patch) != "[]" {
+		t.Errorf("got patch %s, want []", patch)
+	}
+}
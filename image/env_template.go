@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// EnvVar is an environment variable to inject. Its value may be a Go template that is
+// expanded against the admitted pod, e.g. `{{ .Labels.app }}` or `{{ .Namespace }}-{{ .Container.Name }}`.
+type EnvVar struct {
+	corev1.EnvVar `yaml:",inline"`
+
+	// Default is used when the template cannot be expanded, e.g. it references a missing
+	// label. Without a default such a template fails the mutation.
+	Default *string `yaml:"default,omitempty" json:"default,omitempty"`
+
+	ContainerTarget `yaml:",inline"`
+	// MergeStrategy overrides the env block merge strategy for this variable
+	MergeStrategy MergeStrategy `yaml:"mergeStrategy,omitempty" json:"mergeStrategy,omitempty"`
+}
+
+// envTemplateData is the data available to env var value templates
+type envTemplateData struct {
+	Name               string
+	Namespace          string
+	GenerateName       string
+	Labels             map[string]string
+	Annotations        map[string]string
+	ServiceAccountName string
+	Container          corev1.Container
+}
+
+// isEnvTemplate reports whether the value needs to be expanded
+func isEnvTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+// parseEnvTemplate parses an env var value template. Missing map keys are errors so that
+// a missing label or annotation is not silently expanded to "<no value>".
+func parseEnvTemplate(name, value string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(value)
+}
+
+func newEnvTemplateData(pod *corev1.Pod, container *corev1.Container) envTemplateData {
+	return envTemplateData{
+		Name:               pod.Name,
+		Namespace:          pod.Namespace,
+		GenerateName:       pod.GenerateName,
+		Labels:             pod.Labels,
+		Annotations:        pod.Annotations,
+		ServiceAccountName: pod.Spec.ServiceAccountName,
+		Container:          *container,
+	}
+}
+
+// renderEnv expands the templated values of envVars for the given container of the pod
+func renderEnv(envVars []EnvVar, pod *corev1.Pod, container *corev1.Container) ([]EnvVar, error) {
+	data := newEnvTemplateData(pod, container)
+
+	rendered := make([]EnvVar, 0, len(envVars))
+	for _, envVar := range envVars {
+		env := envVar
+		if isEnvTemplate(env.Value) {
+			value, err := executeEnvTemplate(env.Name, env.Value, data)
+			if err != nil {
+				if envVar.Default == nil {
+					return nil, fmt.Errorf("failed to expand env var %s for container %s: %w", env.Name, container.Name, err)
+				}
+				structuredLog(LogLevelInfo, "EnvVars", "Using default value for environment variable %s in container %s: %v", env.Name, container.Name, err)
+				value = *envVar.Default
+			}
+			env.Value = value
+		}
+		rendered = append(rendered, env)
+	}
+	return rendered, nil
+}
+
+func executeEnvTemplate(name, value string, data envTemplateData) (string, error) {
+	tmpl, err := parseEnvTemplate(name, value)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
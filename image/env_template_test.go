@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvTemplates(t *testing.T) {
+	pod := testPod(t, `
+metadata:
+  generateName: web-7d9f-
+  namespace: shop
+  labels:
+    app: checkout
+  annotations:
+    team: payments
+spec:
+  serviceAccountName: checkout-sa
+  containers:
+    - name: app
+      image: nginx
+`)
+
+	tests := []struct {
+		name    string
+		config  string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "pod metadata",
+			config: `
+env:
+  - name: OTEL_SERVICE_NAME
+    value: "{{ .Labels.app }}"
+  - name: LOG_TAG
+    value: "{{ .Namespace }}-{{ .Container.Name }}"
+  - name: OWNER
+    value: "{{ .Annotations.team }}/{{ .ServiceAccountName }}"
+  - name: POD_PREFIX
+    value: "{{ .GenerateName }}"
+  - name: STATIC
+    value: plain
+`,
+			want: map[string]string{
+				"OTEL_SERVICE_NAME": "checkout",
+				"LOG_TAG":           "shop-app",
+				"OWNER":             "payments/checkout-sa",
+				"POD_PREFIX":        "web-7d9f-",
+				"STATIC":            "plain",
+			},
+		},
+		{
+			name: "missing label with default",
+			config: `
+env:
+  - name: VERSION
+    value: "{{ .Labels.version }}"
+    default: unknown
+`,
+			want: map[string]string{"VERSION": "unknown"},
+		},
+		{
+			name: "missing label without default",
+			config: `
+env:
+  - name: VERSION
+    value: "{{ .Labels.version }}"
+`,
+			wantErr: `failed to expand env var VERSION for container app`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, _, err := mutateTestPod(pod, testConfig(t, tt.config))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := envValues(patched.Spec.Containers[0])
+			for name, value := range tt.want {
+				if got[name] != value {
+					t.Errorf("env %s = %q, want %q", name, got[name], value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	policyConditionParsed = "Parsed"
+
+	// policyStatusInterval is how often pending mutation counts are written to policy status
+	policyStatusInterval = 30 * time.Second
+	policyStatusTimeout  = 10 * time.Second
+)
+
+// errPolicyNotFound is returned for status updates of policies that were deleted
+var errPolicyNotFound = errors.New("policy not found in cache")
+
+// policyProfilePrefix prefixes the profile names of policies, so that they cannot collide with
+// the profiles of the configuration file
+const policyProfilePrefix = "envpatchpolicy/"
+
+// policyGVR identifies the cluster-scoped EnvPatchPolicy custom resource
+var policyGVR = schema.GroupVersionResource{
+	Group:    "envinjector.wh.net",
+	Version:  "v1alpha1",
+	Resource: "envpatchpolicies",
+}
+
+// EnvPatchPolicy is a mutation profile managed as a custom resource. Its spec has the same
+// shape as a profile in the configuration file.
+type EnvPatchPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   Config               `json:"spec"`
+	Status EnvPatchPolicyStatus `json:"status,omitempty"`
+}
+
+// EnvPatchPolicyStatus reports whether the policy could be parsed and how often it was applied
+type EnvPatchPolicyStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	MutatedPods        int64              `json:"mutatedPods,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// policyStore keeps the EnvPatchPolicy objects in an informer cache and exposes the valid ones
+// as profiles. It only depends on dynamic.Interface so it can run against a fake client.
+type policyStore struct {
+	client     dynamic.Interface
+	informer   cache.SharedIndexInformer
+	profiles   atomic.Pointer[[]Profile]
+	references bool // whether reference checks are enabled, set before run
+
+	mu         sync.Mutex
+	mutated    map[string]int64            // mutation counts not yet written to policy status
+	conditions map[string]metav1.Condition // Parsed conditions not yet written to policy status
+}
+
+func newPolicyStore(client dynamic.Interface, resync time.Duration) *policyStore {
+	ps := &policyStore{
+		client:     client,
+		mutated:    map[string]int64{},
+		conditions: map[string]metav1.Condition{},
+		informer: dynamicinformer.NewFilteredDynamicInformer(client, policyGVR, metav1.NamespaceAll, resync,
+			cache.Indexers{}, nil).Informer(),
+	}
+	ps.profiles.Store(&[]Profile{})
+
+	ps.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { ps.sync() },
+		UpdateFunc: func(oldObj, newObj interface{}) { ps.sync() },
+		DeleteFunc: func(obj interface{}) { ps.sync() },
+	})
+	return ps
+}
+
+// run starts the informer and the status writer and blocks until the cache has synced
+func (ps *policyStore) run(stop <-chan struct{}) error {
+	go ps.informer.Run(stop)
+	if !cache.WaitForCacheSync(stop, ps.informer.HasSynced) {
+		return fmt.Errorf("failed to sync %s informer cache", policyGVR.Resource)
+	}
+
+	go func() {
+		ticker := time.NewTicker(policyStatusInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ps.flushStatus()
+			case <-stop:
+				ps.flushStatus()
+				return
+			}
+		}
+	}()
+
+	structuredLog(LogLevelInfo, "Policy", "EnvPatchPolicy 缓存已同步，共 %d 个有效策略", len(ps.Profiles()))
+	return nil
+}
+
+// Profiles returns the valid policies as profiles named "envpatchpolicy/<name>", ordered by
+// policy name
+func (ps *policyStore) Profiles() []Profile {
+	return *ps.profiles.Load()
+}
+
+// recordMutation counts a mutation for every policy among the applied profiles
+func (ps *policyStore) recordMutation(profiles []Profile) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for _, profile := range profiles {
+		if profile.policy != "" {
+			ps.mutated[profile.policy]++
+		}
+	}
+}
+
+// sync rebuilds the profile snapshot from the informer cache and queues an update of the Parsed
+// condition of every policy whose parse result changed. It runs in the informer event handlers,
+// so the status itself is written by flushStatus.
+func (ps *policyStore) sync() {
+	var profiles []Profile
+	for _, obj := range ps.informer.GetStore().List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+
+		policy, parseErr := parsePolicy(u)
+		condition := metav1.Condition{
+			Type:               policyConditionParsed,
+			Status:             metav1.ConditionTrue,
+			Reason:             "Valid",
+			Message:            "policy parsed successfully",
+			ObservedGeneration: u.GetGeneration(),
+		}
+		if parseErr != nil {
+			structuredLog(LogLevelError, "Policy", "无法解析 EnvPatchPolicy %s: %v", u.GetName(), parseErr)
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "Invalid"
+			condition.Message = parseErr.Error()
+		} else {
+			profiles = append(profiles, Profile{Name: policyProfilePrefix + policy.Name, Config: policy.Spec, policy: policy.Name})
+			if paths := uncheckedReferences(&policy.Spec, field.NewPath("spec")); len(paths) > 0 && !ps.references {
+				condition.Message = fmt.Sprintf("policy parsed successfully, requireExists has no effect without -checkReferences: %s",
+					strings.Join(paths, ", "))
+			}
+		}
+
+		existing := meta.FindStatusCondition(policy.Status.Conditions, policyConditionParsed)
+		if existing == nil || existing.Status != condition.Status || existing.Message != condition.Message ||
+			existing.ObservedGeneration != condition.ObservedGeneration {
+			ps.mu.Lock()
+			ps.conditions[u.GetName()] = condition
+			ps.mu.Unlock()
+		}
+	}
+
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	ps.profiles.Store(&profiles)
+	structuredLog(LogLevelDebug, "Policy", "EnvPatchPolicy 快照已更新，共 %d 个有效策略", len(profiles))
+}
+
+// flushStatus writes the queued Parsed conditions and adds the pending mutation counts to the
+// mutatedPods status of each policy
+func (ps *policyStore) flushStatus() {
+	ps.mu.Lock()
+	mutated, conditions := ps.mutated, ps.conditions
+	ps.mutated, ps.conditions = map[string]int64{}, map[string]metav1.Condition{}
+	ps.mu.Unlock()
+
+	names := map[string]bool{}
+	for name := range mutated {
+		names[name] = true
+	}
+	for name := range conditions {
+		names[name] = true
+	}
+
+	for name := range names {
+		count := mutated[name]
+		condition, hasCondition := conditions[name]
+		err := ps.updateStatus(name, func(status *EnvPatchPolicyStatus) {
+			status.MutatedPods += count
+			if hasCondition {
+				status.ObservedGeneration = condition.ObservedGeneration
+				meta.SetStatusCondition(&status.Conditions, condition)
+			}
+		})
+		if err == nil || errors.Is(err, errPolicyNotFound) {
+			continue
+		}
+
+		// keep the update for the next attempt, unless sync queued a newer condition meanwhile
+		ps.mu.Lock()
+		ps.mutated[name] += count
+		if _, queued := ps.conditions[name]; hasCondition && !queued {
+			ps.conditions[name] = condition
+		}
+		ps.mu.Unlock()
+	}
+}
+
+// updateStatus applies mutateFn to the cached status of the named policy and writes it back
+// through the status subresource
+func (ps *policyStore) updateStatus(name string, mutateFn func(status *EnvPatchPolicyStatus)) error {
+	obj, exists, err := ps.informer.GetStore().GetByKey(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", errPolicyNotFound, name)
+	}
+	u := obj.(*unstructured.Unstructured).DeepCopy()
+
+	policy, _ := parsePolicy(u)
+	mutateFn(&policy.Status)
+	status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&policy.Status)
+	if err != nil {
+		return err
+	}
+	if err := unstructured.SetNestedField(u.Object, status, "status"); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), policyStatusTimeout)
+	defer cancel()
+	if _, err := ps.client.Resource(policyGVR).UpdateStatus(ctx, u, metav1.UpdateOptions{}); err != nil {
+		structuredLog(LogLevelWarning, "Policy", "无法更新 EnvPatchPolicy %s 的状态: %v", name, err)
+		return err
+	}
+	return nil
+}
+
+// parsePolicy converts the unstructured object into an EnvPatchPolicy. The spec is decoded and
+// validated the same way as a profile in the configuration file. The returned policy
+// always carries the object metadata and status, even when the spec is invalid.
+func parsePolicy(u *unstructured.Unstructured) (*EnvPatchPolicy, error) {
+	policy := &EnvPatchPolicy{}
+	policy.ObjectMeta = metav1.ObjectMeta{Name: u.GetName(), Generation: u.GetGeneration()}
+	if status, ok := u.Object["status"].(map[string]interface{}); ok {
+		_ = runtime.DefaultUnstructuredConverter.FromUnstructured(status, &policy.Status)
+	}
+
+	data, err := json.Marshal(u.Object["spec"])
+	if err != nil {
+		return policy, err
+	}
+	if errs := decodeConfig(data, &policy.Spec); len(errs) > 0 {
+		return policy, fmt.Errorf("invalid spec: %w", utilerrors.NewAggregate(errs))
+	}
+	if errs := validateConfig(&policy.Spec, field.NewPath("spec")); len(errs) > 0 {
+		return policy, errs.ToAggregate()
+	}
+	return policy, nil
+}
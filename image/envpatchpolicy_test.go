@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newTestPolicy(name string, spec map[string]interface{}) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	u.SetAPIVersion(policyGVR.GroupVersion().String())
+	u.SetKind("EnvPatchPolicy")
+	u.SetName(name)
+	u.SetGeneration(1)
+	return u
+}
+
+// envSpec returns a policy spec injecting a single env var
+func envSpec(name, value string) map[string]interface{} {
+	return map[string]interface{}{"env": []interface{}{map[string]interface{}{"name": name, "value": value}}}
+}
+
+// newTestPolicyStore returns a synced store backed by a fake client holding the given policies
+func newTestPolicyStore(t *testing.T, policies ...runtime.Object) (*policyStore, *dynamicfake.FakeDynamicClient) {
+	t.Helper()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{policyGVR: "EnvPatchPolicyList"}, policies...)
+	ps := newPolicyStore(client, 0)
+
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	if err := ps.run(stop); err != nil {
+		t.Fatal(err)
+	}
+	// the event handlers run asynchronously, sync once more to observe the complete cache
+	ps.sync()
+	return ps, client
+}
+
+func getTestPolicyStatus(t *testing.T, client *dynamicfake.FakeDynamicClient, name string) EnvPatchPolicyStatus {
+	t.Helper()
+	u, err := client.Resource(policyGVR).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, _ := parsePolicy(u)
+	return policy.Status
+}
+
+func TestPolicyStoreProfiles(t *testing.T) {
+	ps, _ := newTestPolicyStore(t,
+		newTestPolicy("zeta", envSpec("TEAM", "zeta")),
+		newTestPolicy("alpha", envSpec("TEAM", "alpha")),
+		newTestPolicy("broken", map[string]interface{}{"env": "not a list"}),
+	)
+
+	profiles := ps.Profiles()
+	if len(profiles) != 2 {
+		t.Fatalf("got %d profiles, want 2: %+v", len(profiles), profiles)
+	}
+	for i, want := range []string{"alpha", "zeta"} {
+		if profiles[i].Name != "envpatchpolicy/"+want {
+			t.Errorf("profiles[%d] = %s, want envpatchpolicy/%s", i, profiles[i].Name, want)
+		}
+		if profiles[i].policy != want {
+			t.Errorf("profile %s comes from policy %q, want %s", profiles[i].Name, profiles[i].policy, want)
+		}
+		if len(profiles[i].Env) != 1 || profiles[i].Env[0].Value != want {
+			t.Errorf("profile %s has env %v", profiles[i].Name, profiles[i].Env)
+		}
+	}
+}
+
+func TestPolicyStoreConditions(t *testing.T) {
+	ps, client := newTestPolicyStore(t,
+		newTestPolicy("valid", envSpec("TEAM", "a")),
+		newTestPolicy("broken", map[string]interface{}{"env": "not a list"}),
+	)
+
+	// sync only queues the conditions
+	if status := getTestPolicyStatus(t, client, "valid"); len(status.Conditions) != 0 {
+		t.Fatalf("status written before flush: %+v", status)
+	}
+	ps.flushStatus()
+
+	tests := []struct {
+		name   string
+		status metav1.ConditionStatus
+		reason string
+	}{
+		{name: "valid", status: metav1.ConditionTrue, reason: "Valid"},
+		{name: "broken", status: metav1.ConditionFalse, reason: "Invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := getTestPolicyStatus(t, client, tt.name)
+			condition := meta.FindStatusCondition(status.Conditions, policyConditionParsed)
+			if condition == nil {
+				t.Fatalf("no %s condition in %+v", policyConditionParsed, status)
+			}
+			if condition.Status != tt.status || condition.Reason != tt.reason {
+				t.Errorf("condition = %s/%s, want %s/%s", condition.Status, condition.Reason, tt.status, tt.reason)
+			}
+			if status.ObservedGeneration != 1 {
+				t.Errorf("observedGeneration = %d, want 1", status.ObservedGeneration)
+			}
+		})
+	}
+}
+
+func TestPolicyStoreUncheckedReferences(t *testing.T) {
+	spec := map[string]interface{}{"envFrom": []interface{}{
+		map[string]interface{}{"configMapRef": map[string]interface{}{"name": "proxy"}, "requireExists": true},
+	}}
+	for _, references := range []bool{false, true} {
+		ps, client := newTestPolicyStore(t, newTestPolicy("proxy", spec))
+		ps.references = references
+		ps.sync()
+		ps.flushStatus()
+
+		condition := meta.FindStatusCondition(getTestPolicyStatus(t, client, "proxy").Conditions, policyConditionParsed)
+		if condition == nil || condition.Status != metav1.ConditionTrue {
+			t.Fatalf("references %v: got condition %+v, want %s", references, condition, metav1.ConditionTrue)
+		}
+		if got := strings.Contains(condition.Message, "spec.envFrom[0].requireExists"); got == references {
+			t.Errorf("references %v: got message %q", references, condition.Message)
+		}
+	}
+}
+
+func TestPolicyStoreMutatedPods(t *testing.T) {
+	ps, client := newTestPolicyStore(t,
+		newTestPolicy("spot", envSpec("SPOT", "true")),
+	)
+	ps.flushStatus()
+
+	// a file profile with the name of the policy is not counted for the policy
+	fileProfile := Profile{Name: "spot"}
+	policyProfile := ps.Profiles()[0]
+	ps.recordMutation([]Profile{fileProfile, policyProfile})
+	ps.recordMutation([]Profile{policyProfile})
+	// policies deleted before the flush are dropped
+	ps.recordMutation([]Profile{{Name: "envpatchpolicy/deleted", policy: "deleted"}})
+
+	// the flush writes against the informer cache, wait for the condition update to reach it
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		obj, _, _ := ps.informer.GetStore().GetByKey("spot")
+		if policy, _ := parsePolicy(obj.(*unstructured.Unstructured)); len(policy.Status.Conditions) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("condition update did not reach the informer cache")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ps.flushStatus()
+
+	if status := getTestPolicyStatus(t, client, "spot"); status.MutatedPods != 2 {
+		t.Errorf("mutatedPods = %d, want 2", status.MutatedPods)
+	}
+	if len(ps.mutated) != 0 {
+		t.Errorf("pending counts left after flush: %v", ps.mutated)
+	}
+}
@@ -4,13 +4,16 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/ghodss/yaml"
 	"github.com/golang/glog"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	kjson "sigs.k8s.io/json"
+	"sigs.k8s.io/yaml"
 )
 
 // LogLevel 定义日志级别
@@ -41,25 +44,89 @@ func structuredLog(level LogLevel, component string, format string, args ...inte
 	}
 }
 
-func loadConfig(configFile string) (*Config, error) {
+// loadConfig reads and validates the mutation configuration file. A file without a `profiles`
+// list is treated as a single profile named "default" so existing configurations keep working.
+// Errors reference the offending line of the file.
+func loadConfig(configFile string) (*EnvConfig, error) {
 	data, err := os.ReadFile(configFile)
 	if err != nil {
 		return nil, err
 	}
-	structuredLog(LogLevelInfo, "Config", "新配置文件校验和: sha256sum %x", sha256.Sum256(data))
+	checksum := sha256.Sum256(data)
+	structuredLog(LogLevelInfo, "Config", "新配置文件校验和: sha256sum %x", checksum)
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, err
+	cfg, errs := parseConfig(data)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n%s", strings.Join(configErrorLines(configFile, data, errs), "\n"))
+	}
+	cfg.checksum = checksum
+	structuredLog(LogLevelDebug, "Config", "配置数据: %+v", cfg)
+
+	return cfg, nil
+}
+
+// parseConfig strictly decodes the configuration, rejecting unknown fields, and validates it
+func parseConfig(data []byte) (*EnvConfig, []error) {
+	var probe map[string]interface{}
+	if err := yaml.Unmarshal(data, &probe); err != nil {
+		return nil, []error{err}
+	}
+
+	var cfg EnvConfig
+	if _, ok := probe["profiles"]; ok {
+		if errs := decodeConfig(data, &cfg); len(errs) > 0 {
+			return nil, errs
+		}
+	} else {
+		var legacy Config
+		if errs := decodeConfig(data, &legacy); len(errs) > 0 {
+			return nil, errs
+		}
+		cfg.Profiles = []Profile{{Name: defaultProfileName, Config: legacy}}
+		cfg.legacy = true
 	}
-	structuredLog(LogLevelDebug, "Config", "配置数据: %+v", &cfg)
 
+	if errs := validateEnvConfig(&cfg); len(errs) > 0 {
+		return nil, errs.ToAggregate().Errors()
+	}
 	return &cfg, nil
 }
 
+// decodeConfig strictly decodes YAML into the configuration type v. Keys are matched case
+// sensitively, so `Tolerations:` is reported as an unknown field like any other typo, and
+// duplicate keys are rejected. Unknown fields are reported with their full field path.
+func decodeConfig(data []byte, v interface{}) []error {
+	jsonData, err := yaml.YAMLToJSONStrict(data)
+	if err != nil {
+		return []error{err}
+	}
+	strictErrs, err := kjson.UnmarshalStrict(jsonData, v)
+	if err != nil {
+		return []error{err}
+	}
+	return strictErrs
+}
+
 // mutationRequired checks whether the target resource needs to be mutated.
 // Mutation is enabled by default unless explicitly disabled.
-func mutationRequired(ignoredList []string, metadata *metav1.ObjectMeta, config *Config) bool {
+func mutationRequired(ignoredList []string, metadata *metav1.ObjectMeta) bool {
+	if !mutationAllowed(ignoredList, metadata) {
+		return false
+	}
+
+	// 检查是否已经注入
+	if isInjected(metadata.GetAnnotations()[admissionWebhookAnnotationStatusKey]) {
+		structuredLog(LogLevelInfo, "Mutation", "跳过 %v/%v 的变更: 已经注入", metadata.Namespace, metadata.Name)
+		return false
+	}
+
+	structuredLog(LogLevelInfo, "Mutation", "需要对 %v/%v 进行变更", metadata.Namespace, metadata.Name)
+	return true
+}
+
+// mutationAllowed checks that the target resource is neither in an ignored namespace
+// nor explicitly opted out of injection
+func mutationAllowed(ignoredList []string, metadata *metav1.ObjectMeta) bool {
 	// skip excluded kubernetes system namespaces
 	for _, namespace := range ignoredList {
 		if metadata.Namespace == namespace {
@@ -73,42 +140,89 @@ func mutationRequired(ignoredList []string, metadata *metav1.ObjectMeta, config
 		annotations = map[string]string{}
 	}
 
-	// 检查是否已经注入
-	if strings.ToLower(annotations[admissionWebhookAnnotationStatusKey]) == "injected" {
-		structuredLog(LogLevelInfo, "Mutation", "跳过 %v/%v 的变更: 已经注入", metadata.Namespace, metadata.Name)
+	// 检查是否明确禁用注入
+	if annotationDisabled(annotations[admissionWebhookAnnotationInjectKey]) {
+		structuredLog(LogLevelInfo, "Mutation", "跳过 %v/%v 的变更: 明确禁用注入", metadata.Namespace, metadata.Name)
 		return false
 	}
 
-	// 检查是否明确禁用注入
-	if val := annotations[admissionWebhookAnnotationInjectKey]; strings.ToLower(val) == "no" ||
-		strings.ToLower(val) == "false" || strings.ToLower(val) == "off" {
-		structuredLog(LogLevelInfo, "Mutation", "跳过 %v/%v 的变更: 明确禁用注入", metadata.Namespace, metadata.Name)
+	return true
+}
+
+// sidecarInjectionAllowed checks that the target resource is not opted out of sidecar injection
+func sidecarInjectionAllowed(metadata *metav1.ObjectMeta) bool {
+	if annotationDisabled(metadata.GetAnnotations()[admissionWebhookAnnotationSidecarKey]) {
+		structuredLog(LogLevelInfo, "Mutation", "跳过 %v/%v 的 sidecar 注入: 明确禁用注入", metadata.Namespace, metadata.Name)
 		return false
 	}
+	return true
+}
 
-	// 如果配置了Pod选择器，检查Pod是否匹配
-	if config != nil && config.PodSelector != nil {
-		selector, err := metav1.LabelSelectorAsSelector(config.PodSelector)
-		if err != nil {
-			structuredLog(LogLevelError, "Mutation", "无效的 pod 选择器: %v", err)
-			return false
-		}
+// annotationDisabled reports whether an opt-out annotation value disables injection
+func annotationDisabled(val string) bool {
+	val = strings.ToLower(val)
+	return val == "no" || val == "false" || val == "off"
+}
 
-		// 检查Pod的标签是否匹配选择器
-		if !selector.Matches(labels.Set(metadata.Labels)) {
-			structuredLog(LogLevelInfo, "Mutation", "Pod %s/%s 不匹配标签选择器", metadata.Namespace, metadata.Name)
-			return false
+// matchingProfiles returns the profiles whose podSelector matches the target resource, in
+// the order they are declared in the configuration file
+func matchingProfiles(config *EnvConfig, metadata *metav1.ObjectMeta) []Profile {
+	var matched []Profile
+	if config == nil {
+		return matched
+	}
+
+	for _, profile := range config.Profiles {
+		// 如果配置了Pod选择器，检查Pod是否匹配
+		if profile.PodSelector != nil {
+			selector, err := metav1.LabelSelectorAsSelector(profile.PodSelector)
+			if err != nil {
+				structuredLog(LogLevelError, "Mutation", "配置档案 %s 的 pod 选择器无效: %v", profile.Name, err)
+				continue
+			}
+
+			// 检查Pod的标签是否匹配选择器
+			if !selector.Matches(labels.Set(metadata.Labels)) {
+				structuredLog(LogLevelInfo, "Mutation", "Pod %s/%s 不匹配配置档案 %s 的标签选择器", metadata.Namespace, metadata.Name, profile.Name)
+				continue
+			}
 		}
+
+		structuredLog(LogLevelInfo, "Mutation", "Pod %s/%s 匹配配置档案 %s", metadata.Namespace, metadata.Name, profile.Name)
+		matched = append(matched, profile)
 	}
+	return matched
+}
 
-	structuredLog(LogLevelInfo, "Mutation", "需要对 %v/%v 进行变更", metadata.Namespace, metadata.Name)
-	return true
+// injectionStatus builds the status annotation value, recording the applied profiles,
+// e.g. "injected; profiles=web,spot"
+func injectionStatus(profiles []Profile) string {
+	names := make([]string, 0, len(profiles))
+	for _, profile := range profiles {
+		names = append(names, profile.Name)
+	}
+	return fmt.Sprintf("%s; profiles=%s", injectedStatus, strings.Join(names, ","))
+}
+
+// isInjected reports whether a status annotation value marks the resource as already mutated.
+// Both the bare "injected" value and the value written by injectionStatus are recognized.
+func isInjected(status string) bool {
+	state, _, _ := strings.Cut(status, ";")
+	return strings.ToLower(strings.TrimSpace(state)) == injectedStatus
 }
 
+// updateAnnotation sets the webhook annotations, replacing existing values
 func updateAnnotation(target map[string]string, annotations map[string]string) (patch []patchOperation) {
-	for k, v := range annotations {
-		if target == nil {
-			target = map[string]string{}
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := annotations[k]
+		if len(target) == 0 {
+			target = map[string]string{k: v}
 			patch = append(patch, patchOperation{
 				Op:   "add",
 				Path: "/metadata/annotations",
@@ -116,17 +230,16 @@ func updateAnnotation(target map[string]string, annotations map[string]string) (
 					k: v,
 				},
 			})
-		} else if target[k] == "" {
-			target = map[string]string{}
+		} else if _, ok := target[k]; !ok {
 			patch = append(patch, patchOperation{
 				Op:    "add",
-				Path:  "/metadata/annotations/" + k,
+				Path:  jsonPointer("/metadata/annotations", k),
 				Value: v,
 			})
 		} else {
 			patch = append(patch, patchOperation{
 				Op:    "replace",
-				Path:  "/metadata/annotations/" + k,
+				Path:  jsonPointer("/metadata/annotations", k),
 				Value: v,
 			})
 		}
@@ -134,13 +247,32 @@ func updateAnnotation(target map[string]string, annotations map[string]string) (
 	return patch
 }
 
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// escapeJSONPointer escapes a reference token of a JSON pointer as defined by RFC 6901, so
+// that a key such as example.com/team addresses a single member
+func escapeJSONPointer(token string) string {
+	return jsonPointerEscaper.Replace(token)
+}
+
+// jsonPointer appends the escaped reference tokens to the base JSON pointer
+func jsonPointer(base string, tokens ...string) string {
+	var b strings.Builder
+	b.WriteString(base)
+	for _, token := range tokens {
+		b.WriteString("/")
+		b.WriteString(escapeJSONPointer(token))
+	}
+	return b.String()
+}
+
 // function to test conditions pased in and determine if we need to replace existing config or skip it when it matches
 func checkReplaceOrSkip(idx int, inPath string, conditions ...bool) (skip bool, op, path string) {
 
 	for _, condition := range conditions {
 		if !condition {
 			op = "replace"
-			path = fmt.Sprintf("%s/%d", inPath, idx)
+			path = jsonPointer(inPath, strconv.Itoa(idx))
 			skip = false
 			return
 		}
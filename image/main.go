@@ -9,9 +9,19 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
+
 	var parameters WhSvrParameters
 
 	// get command line parameters
@@ -19,6 +29,10 @@ func main() {
 	flag.StringVar(&parameters.certFile, "tlsCertFile", "/etc/webhook/certs/cert.pem", "File containing the x509 Certificate for HTTPS.")
 	flag.StringVar(&parameters.keyFile, "tlsKeyFile", "/etc/webhook/certs/key.pem", "File containing the x509 private key to --tlsCertFile.")
 	flag.StringVar(&parameters.envCfgFile, "envCfgFile", "/etc/webhook/config/envconfig.yaml", "File containing the mutation configuration.")
+	flag.StringVar(&parameters.kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
+	flag.BoolVar(&parameters.policies, "enablePolicies", false, "Load mutation profiles from EnvPatchPolicy custom resources.")
+	flag.BoolVar(&parameters.references, "checkReferences", false, "Check that ConfigMaps and Secrets referenced with requireExists exist.")
+	flag.BoolVar(&parameters.workloads, "mutateWorkloads", false, "Mutate the pod templates of Deployments, StatefulSets, DaemonSets, Jobs and CronJobs.")
 	flag.Parse()
 
 	envConfig, err := loadConfig(parameters.envCfgFile)
@@ -28,7 +42,6 @@ func main() {
 	}
 
 	whsvr := &WebhookServer{
-		envConfig: envConfig,
 		server: &http.Server{
 			Addr: fmt.Sprintf(":%v", parameters.port),
 			TLSConfig: &tls.Config{
@@ -44,6 +57,55 @@ func main() {
 		},
 	}
 
+	whsvr.workloads = parameters.workloads
+	whsvr.envConfig.Store(envConfig)
+
+	stopCh := make(chan struct{})
+	var restConfig *rest.Config
+	if parameters.policies || parameters.references {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", parameters.kubeconfig)
+		if err != nil {
+			structuredLog(LogLevelError, "Main", "加载 kubeconfig 失败: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if parameters.policies {
+		client, err := dynamic.NewForConfig(restConfig)
+		if err != nil {
+			structuredLog(LogLevelError, "Main", "创建 Kubernetes 客户端失败: %v", err)
+			os.Exit(1)
+		}
+		whsvr.policies = newPolicyStore(client, 10*time.Minute)
+		whsvr.policies.references = parameters.references
+		if err := whsvr.policies.run(stopCh); err != nil {
+			structuredLog(LogLevelError, "Main", "启动 EnvPatchPolicy 监听失败: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if parameters.references {
+		client, err := metadata.NewForConfig(restConfig)
+		if err != nil {
+			structuredLog(LogLevelError, "Main", "创建 Kubernetes 元数据客户端失败: %v", err)
+			os.Exit(1)
+		}
+		whsvr.objects = newObjectCache(client, 10*time.Minute, configMapGVR, secretGVR)
+		if err := whsvr.objects.run(stopCh); err != nil {
+			structuredLog(LogLevelError, "Main", "启动引用对象缓存失败: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if whsvr.objects == nil {
+		warnUncheckedReferences(envConfig)
+	}
+
+	// reload the configuration when the mounted ConfigMap changes
+	if err := whsvr.watchConfig(parameters.envCfgFile, stopCh); err != nil {
+		structuredLog(LogLevelWarning, "Main", "无法监听配置文件，配置热加载已禁用: %v", err)
+	}
+
 	// define http server and server handler
 	mux := http.NewServeMux()
 	mux.HandleFunc("/mutate", whsvr.serve)
@@ -64,5 +126,23 @@ func main() {
 	<-signalChan
 
 	structuredLog(LogLevelInfo, "Main", "收到系统关闭信号，正在关闭 env-injector-webhook 服务器...")
+	close(stopCh)
 	whsvr.server.Shutdown(context.Background())
 }
+
+// runValidate implements the `validate` subcommand. It checks a configuration file and
+// returns a non-zero exit code with line-referenced errors when the file is invalid.
+func runValidate(args []string) int {
+	validateFlags := flag.NewFlagSet("validate", flag.ExitOnError)
+	envCfgFile := validateFlags.String("envCfgFile", "/etc/webhook/config/envconfig.yaml", "File containing the mutation configuration.")
+	validateFlags.Parse(args)
+
+	envConfig, err := loadConfig(*envCfgFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("%s: OK, %d profile(s)\n", *envCfgFile, len(envConfig.Profiles))
+	return 0
+}
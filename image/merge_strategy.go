@@ -0,0 +1,65 @@
+package main
+
+import "fmt"
+
+// MergeStrategy controls what happens when the pod already defines an entry with the same
+// key as an injected one but a different value
+type MergeStrategy string
+
+const (
+	// MergeStrategyOverride replaces the existing entry, the default
+	MergeStrategyOverride MergeStrategy = "override"
+	// MergeStrategyKeepExisting leaves the existing entry untouched
+	MergeStrategyKeepExisting MergeStrategy = "keepExisting"
+	// MergeStrategyFail rejects the admission request
+	MergeStrategyFail MergeStrategy = "fail"
+)
+
+// MergeStrategies sets the merge strategy of each block. Entries may override it with their
+// own mergeStrategy. SecurityContext defaults to keepExisting as that block only fills in
+// missing fields.
+type MergeStrategies struct {
+	Env                 MergeStrategy `yaml:"env,omitempty" json:"env,omitempty"`
+	EnvFrom             MergeStrategy `yaml:"envFrom,omitempty" json:"envFrom,omitempty"`
+	DnsOptions          MergeStrategy `yaml:"dnsOptions,omitempty" json:"dnsOptions,omitempty"`
+	Tolerations         MergeStrategy `yaml:"tolerations,omitempty" json:"tolerations,omitempty"`
+	TopologyConstraints MergeStrategy `yaml:"topologyConstraints,omitempty" json:"topologyConstraints,omitempty"`
+	Volumes             MergeStrategy `yaml:"volumes,omitempty" json:"volumes,omitempty"`
+	VolumeMounts        MergeStrategy `yaml:"volumeMounts,omitempty" json:"volumeMounts,omitempty"`
+	Sidecars            MergeStrategy `yaml:"sidecars,omitempty" json:"sidecars,omitempty"`
+	Labels              MergeStrategy `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Annotations         MergeStrategy `yaml:"annotations,omitempty" json:"annotations,omitempty"`
+	NodeSelector        MergeStrategy `yaml:"nodeSelector,omitempty" json:"nodeSelector,omitempty"`
+	SecurityContext     MergeStrategy `yaml:"securityContext,omitempty" json:"securityContext,omitempty"`
+	HostAliases         MergeStrategy `yaml:"hostAliases,omitempty" json:"hostAliases,omitempty"`
+}
+
+// or returns the strategy, or fallback when it is not set
+func (s MergeStrategy) or(fallback MergeStrategy) MergeStrategy {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
+
+// checkMergeStrategy extends checkReplaceOrSkip for entries whose existing value may be kept
+// or treated as an error instead of being replaced
+func checkMergeStrategy(strategy MergeStrategy, idx int, inPath, key string, conditions ...bool) (skip bool, op, path string, err error) {
+	skip, op, path = checkReplaceOrSkip(idx, inPath, conditions...)
+	if skip {
+		return
+	}
+	skip, err = resolveConflict(strategy, path, key)
+	return
+}
+
+// resolveConflict applies the strategy to an existing entry that differs from the injected one
+func resolveConflict(strategy MergeStrategy, path, key string) (skip bool, err error) {
+	switch strategy {
+	case MergeStrategyKeepExisting:
+		skip = true
+	case MergeStrategyFail:
+		err = fmt.Errorf("%s: existing entry %q conflicts with the injected value (mergeStrategy: %s)", path, key, strategy)
+	}
+	return
+}
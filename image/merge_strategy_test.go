@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeStrategies(t *testing.T) {
+	pod := testPod(t, `
+spec:
+  containers:
+    - name: app
+      image: nginx
+      env:
+        - name: LOG_LEVEL
+          value: debug
+  dnsConfig:
+    options:
+      - name: ndots
+        value: "2"
+  tolerations:
+    - key: spot
+      operator: Equal
+      value: "false"
+      effect: NoSchedule
+  topologySpreadConstraints:
+    - maxSkew: 3
+      topologyKey: topology.kubernetes.io/zone
+      whenUnsatisfiable: ScheduleAnyway
+`)
+
+	tests := []struct {
+		name    string
+		config  string
+		want    podState
+		wantErr string
+	}{
+		{
+			name: "override by default",
+			config: `
+env:
+  - name: LOG_LEVEL
+    value: info
+dnsOptions:
+  - name: ndots
+    value: "5"
+tolerations:
+  - key: spot
+    operator: Equal
+    value: "true"
+    effect: NoSchedule
+topologyConstraints:
+  - maxSkew: 1
+    topologyKey: topology.kubernetes.io/zone
+    whenUnsatisfiable: DoNotSchedule
+`,
+			want: podState{logLevel: "info", ndots: "5", spotValue: "true", zoneSkew: 1},
+		},
+		{
+			name: "keepExisting block strategies",
+			config: `
+env:
+  - name: LOG_LEVEL
+    value: info
+dnsOptions:
+  - name: ndots
+    value: "5"
+tolerations:
+  - key: spot
+    operator: Equal
+    value: "true"
+    effect: NoSchedule
+topologyConstraints:
+  - maxSkew: 1
+    topologyKey: topology.kubernetes.io/zone
+    whenUnsatisfiable: DoNotSchedule
+mergeStrategies:
+  env: keepExisting
+  dnsOptions: keepExisting
+  tolerations: keepExisting
+  topologyConstraints: keepExisting
+`,
+			want: podState{logLevel: "debug", ndots: "2", spotValue: "false", zoneSkew: 3},
+		},
+		{
+			name: "entry strategy overrides block strategy",
+			config: `
+env:
+  - name: LOG_LEVEL
+    value: info
+    mergeStrategy: override
+  - name: REGION
+    value: westeurope
+mergeStrategies:
+  env: fail
+`,
+			want: podState{logLevel: "info", region: "westeurope", ndots: "2", spotValue: "false", zoneSkew: 3},
+		},
+		{
+			name: "equal values never conflict",
+			config: `
+env:
+  - name: LOG_LEVEL
+    value: debug
+mergeStrategies:
+  env: fail
+`,
+			want: podState{logLevel: "debug", ndots: "2", spotValue: "false", zoneSkew: 3},
+		},
+		{
+			name: "fail names the conflicting field",
+			config: `
+tolerations:
+  - key: spot
+    operator: Equal
+    value: "true"
+    effect: NoSchedule
+    mergeStrategy: fail
+`,
+			wantErr: `/spec/tolerations/0: existing entry "spot" conflicts with the injected value (mergeStrategy: fail)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, _, err := mutateTestPod(pod, testConfig(t, tt.config))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			env := envValues(patched.Spec.Containers[0])
+			state := podState{logLevel: env["LOG_LEVEL"], region: env["REGION"]}
+			for _, opt := range patched.Spec.DNSConfig.Options {
+				if opt.Name == "ndots" && opt.Value != nil {
+					state.ndots = *opt.Value
+				}
+			}
+			for _, toleration := range patched.Spec.Tolerations {
+				if toleration.Key == "spot" {
+					state.spotValue = toleration.Value
+				}
+			}
+			for _, constraint := range patched.Spec.TopologySpreadConstraints {
+				if constraint.TopologyKey == "topology.kubernetes.io/zone" {
+					state.zoneSkew = constraint.MaxSkew
+				}
+			}
+			if state != tt.want {
+				t.Errorf("got %+v, want %+v", state, tt.want)
+			}
+		})
+	}
+}
+
+// podState holds the fields of the mutated pod the merge strategy tests look at
+type podState struct {
+	logLevel  string
+	region    string
+	ndots     string
+	spotValue string
+	zoneSkew  int32
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// mutationContext carries the state of a single admission request through patch creation
+type mutationContext struct {
+	objects   *objectCache // nil unless reference checks are enabled
+	warnings  []string     // returned to the client as admission warnings
+	resources []string     // containers whose resources were changed, for the status annotation
+}
+
+// warn records an admission warning shown to the user who created the pod
+func (mc *mutationContext) warn(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	structuredLog(LogLevelWarning, "Patch", "%s", msg)
+	mc.warnings = append(mc.warnings, msg)
+}
+
+// referenceExists reports whether the referenced object exists. Without reference checks the
+// object is assumed to exist.
+func (mc *mutationContext) referenceExists(gvr schema.GroupVersionResource, namespace, name string) (bool, error) {
+	if mc.objects == nil {
+		structuredLog(LogLevelDebug, "Patch", "引用检查未启用，假定 %s %s/%s 存在", gvr.Resource, namespace, name)
+		return true, nil
+	}
+	return mc.objects.exists(gvr, namespace, name)
+}
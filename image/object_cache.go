@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+)
+
+var (
+	configMapGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	secretGVR    = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+)
+
+// objectCache keeps the metadata of the objects referenced by injected fields in informer
+// caches so that their existence can be checked without an API call per admission request.
+// Only metadata is cached, the content of secrets never reaches the webhook. The list and watch
+// permissions it needs do cover secret content though, see deployment/rbac-references.yaml.
+type objectCache struct {
+	factory   metadatainformer.SharedInformerFactory
+	resources []schema.GroupVersionResource
+}
+
+func newObjectCache(client metadata.Interface, resync time.Duration, resources ...schema.GroupVersionResource) *objectCache {
+	oc := &objectCache{
+		factory:   metadatainformer.NewSharedInformerFactory(client, resync),
+		resources: resources,
+	}
+	for _, gvr := range resources {
+		// register the informer before the factory is started
+		oc.factory.ForResource(gvr).Informer()
+	}
+	return oc
+}
+
+// run starts the informers and blocks until their caches have synced
+func (oc *objectCache) run(stop <-chan struct{}) error {
+	oc.factory.Start(stop)
+	for gvr, synced := range oc.factory.WaitForCacheSync(stop) {
+		if !synced {
+			return fmt.Errorf("failed to sync %s informer cache", gvr.Resource)
+		}
+	}
+
+	structuredLog(LogLevelInfo, "Objects", "引用对象缓存已同步: %v", oc.resources)
+	return nil
+}
+
+// exists reports whether the object is in the cache. Objects of resources the cache does not
+// watch are reported as an error.
+func (oc *objectCache) exists(gvr schema.GroupVersionResource, namespace, name string) (bool, error) {
+	if !oc.watches(gvr) {
+		return false, fmt.Errorf("%s are not cached", gvr.Resource)
+	}
+
+	lister := oc.factory.ForResource(gvr).Lister()
+	var err error
+	if namespace == metav1.NamespaceNone {
+		_, err = lister.Get(name)
+	} else {
+		_, err = lister.ByNamespace(namespace).Get(name)
+	}
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (oc *objectCache) watches(gvr schema.GroupVersionResource) bool {
+	for _, resource := range oc.resources {
+		if resource == gvr {
+			return true
+		}
+	}
+	return false
+}
+
+// uncheckedReferences returns the paths of the requireExists fields of the profile. They have
+// no effect unless reference checks are enabled with -checkReferences.
+func uncheckedReferences(cfg *Config, fldPath *field.Path) []string {
+	var paths []string
+	for i, source := range cfg.EnvFrom {
+		if source.RequireExists {
+			paths = append(paths, fldPath.Child("envFrom").Index(i).Child("requireExists").String())
+		}
+	}
+	for i, secret := range cfg.ImagePullSecrets {
+		if secret.RequireExists {
+			paths = append(paths, fldPath.Child("imagePullSecrets").Index(i).Child("requireExists").String())
+		}
+	}
+	return paths
+}
+
+// warnUncheckedReferences logs the requireExists fields of the configuration file, for a
+// webhook running without reference checks
+func warnUncheckedReferences(envConfig *EnvConfig) {
+	for i, profile := range envConfig.Profiles {
+		fldPath := field.NewPath("profiles").Index(i)
+		if envConfig.legacy {
+			fldPath = nil
+		}
+		for _, path := range uncheckedReferences(&profile.Config, fldPath) {
+			structuredLog(LogLevelWarning, "Config", "未启用 -checkReferences，%s 不生效，不检查引用对象是否存在", path)
+		}
+	}
+}
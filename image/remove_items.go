@@ -0,0 +1,179 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// RemoveRules removes items of the pod spec, e.g. tolerations added by a chart that do not apply
+// to the cluster
+type RemoveRules struct {
+	Env         []EnvRemoval        `yaml:"env,omitempty" json:"env,omitempty"`
+	Tolerations []TolerationRemoval `yaml:"tolerations,omitempty" json:"tolerations,omitempty"`
+	// TopologyConstraintKeys removes the topology spread constraints with these topology keys
+	TopologyConstraintKeys []string `yaml:"topologyConstraintKeys,omitempty" json:"topologyConstraintKeys,omitempty"`
+	// NodeAffinityKeys removes the node affinity terms with a requirement on these node labels
+	NodeAffinityKeys []string `yaml:"nodeAffinityKeys,omitempty" json:"nodeAffinityKeys,omitempty"`
+	// PodAntiAffinityTopologyKeys removes the pod anti-affinity terms with these topology keys
+	PodAntiAffinityTopologyKeys []string `yaml:"podAntiAffinityTopologyKeys,omitempty" json:"podAntiAffinityTopologyKeys,omitempty"`
+}
+
+// EnvRemoval removes the env vars of the selected containers with a name matching NameRegex
+type EnvRemoval struct {
+	NameRegex string `yaml:"nameRegex" json:"nameRegex"`
+
+	ContainerTarget `yaml:",inline"`
+
+	nameRegex *regexp.Regexp // the NameRegex, compiled when the config is validated
+}
+
+// TolerationRemoval removes the tolerations with the key, and with the effect when it is set
+type TolerationRemoval struct {
+	Key    string             `yaml:"key" json:"key"`
+	Effect corev1.TaintEffect `yaml:"effect,omitempty" json:"effect,omitempty"`
+}
+
+// removeItems performs the mutation(s) needed to apply the remove rules to the pod
+func removeItems(pod *corev1.Pod, envConfig *Config) (patch []patchOperation) {
+	rules := envConfig.Remove
+	spec := &pod.Spec
+
+	if len(rules.Env) > 0 {
+		for _, c := range podContainers(spec, ContainerTypeInitContainers, ContainerTypeContainers) {
+			var indices []int
+			for idx, env := range c.Container.Env {
+				if envRemoved(rules.Env, c, env.Name, envConfig.ContainerSelector) {
+					structuredLog(LogLevelInfo, "Remove", "Removing env var %s from %s container %s", env.Name, c.Type, c.Container.Name)
+					indices = append(indices, idx)
+				}
+			}
+			patch = append(patch, removeIndices(jsonPointer(c.Path, "env"), indices)...)
+		}
+	}
+
+	var indices []int
+	for idx, toleration := range spec.Tolerations {
+		for _, rule := range rules.Tolerations {
+			if toleration.Key == rule.Key && (rule.Effect == "" || toleration.Effect == rule.Effect) {
+				structuredLog(LogLevelInfo, "Remove", "Removing toleration with key: %s, effect: %s", toleration.Key, toleration.Effect)
+				indices = append(indices, idx)
+				break
+			}
+		}
+	}
+	patch = append(patch, removeIndices("/spec/tolerations", indices)...)
+
+	indices = nil
+	for idx, constraint := range spec.TopologySpreadConstraints {
+		if containsString(rules.TopologyConstraintKeys, constraint.TopologyKey) {
+			structuredLog(LogLevelInfo, "Remove", "Removing topology spread constraint with topology key: %s", constraint.TopologyKey)
+			indices = append(indices, idx)
+		}
+	}
+	patch = append(patch, removeIndices("/spec/topologySpreadConstraints", indices)...)
+
+	if spec.Affinity != nil && spec.Affinity.NodeAffinity != nil && len(rules.NodeAffinityKeys) > 0 {
+		patch = append(patch, removeNodeAffinityTerms(spec.Affinity.NodeAffinity, rules.NodeAffinityKeys, "/spec/affinity/nodeAffinity")...)
+	}
+	if spec.Affinity != nil && spec.Affinity.PodAntiAffinity != nil && len(rules.PodAntiAffinityTopologyKeys) > 0 {
+		patch = append(patch, removePodAffinityTerms(spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution,
+			spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution, rules.PodAntiAffinityTopologyKeys, "/spec/affinity/podAntiAffinity")...)
+	}
+	return patch
+}
+
+// envRemoved reports whether a rule removes the env var from the container
+func envRemoved(rules []EnvRemoval, c podContainer, name string, defaultSelector *ContainerSelector) bool {
+	for _, rule := range rules {
+		if ok, _ := rule.selects(c, defaultSelector); !ok {
+			continue
+		}
+		if rule.nameRegex.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeNodeAffinityTerms performs the mutation(s) needed to remove the node affinity terms with
+// a requirement on one of the keys. Required terms are ORed and may not be empty, so removing all
+// of them removes the node selector, which leaves the pod unconstrained.
+func removeNodeAffinityTerms(affinity *corev1.NodeAffinity, keys []string, basePath string) (patch []patchOperation) {
+	if required := affinity.RequiredDuringSchedulingIgnoredDuringExecution; required != nil {
+		path := jsonPointer(basePath, "requiredDuringSchedulingIgnoredDuringExecution")
+		var indices []int
+		for idx, term := range required.NodeSelectorTerms {
+			if nodeSelectorTermUsesKey(term, keys) {
+				indices = append(indices, idx)
+			}
+		}
+		if len(indices) > 0 && len(indices) == len(required.NodeSelectorTerms) {
+			structuredLog(LogLevelInfo, "Remove", "Removing all %d required node affinity terms", len(indices))
+			patch = append(patch, patchOperation{Op: "remove", Path: path})
+		} else {
+			patch = append(patch, removeIndices(jsonPointer(path, "nodeSelectorTerms"), indices)...)
+		}
+	}
+
+	var indices []int
+	for idx, term := range affinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		if nodeSelectorTermUsesKey(term.Preference, keys) {
+			indices = append(indices, idx)
+		}
+	}
+	return append(patch, removeIndices(jsonPointer(basePath, "preferredDuringSchedulingIgnoredDuringExecution"), indices)...)
+}
+
+func nodeSelectorTermUsesKey(term corev1.NodeSelectorTerm, keys []string) bool {
+	for _, req := range term.MatchExpressions {
+		if containsString(keys, req.Key) {
+			return true
+		}
+	}
+	for _, req := range term.MatchFields {
+		if containsString(keys, req.Key) {
+			return true
+		}
+	}
+	return false
+}
+
+// removePodAffinityTerms performs the mutation(s) needed to remove the pod (anti-)affinity terms
+// with one of the topology keys
+func removePodAffinityTerms(required []corev1.PodAffinityTerm, preferred []corev1.WeightedPodAffinityTerm, topologyKeys []string, basePath string) (patch []patchOperation) {
+	var indices []int
+	for idx, term := range required {
+		if containsString(topologyKeys, term.TopologyKey) {
+			structuredLog(LogLevelInfo, "Remove", "Removing required pod affinity term with topology key: %s", term.TopologyKey)
+			indices = append(indices, idx)
+		}
+	}
+	patch = append(patch, removeIndices(jsonPointer(basePath, "requiredDuringSchedulingIgnoredDuringExecution"), indices)...)
+
+	indices = nil
+	for idx, term := range preferred {
+		if containsString(topologyKeys, term.PodAffinityTerm.TopologyKey) {
+			structuredLog(LogLevelInfo, "Remove", "Removing preferred pod affinity term with topology key: %s", term.PodAffinityTerm.TopologyKey)
+			indices = append(indices, idx)
+		}
+	}
+	return append(patch, removeIndices(jsonPointer(basePath, "preferredDuringSchedulingIgnoredDuringExecution"), indices)...)
+}
+
+// removeIndices returns the operations removing the items at the indices of an array. Each
+// operation applies to the array as left by the previous ones, so items are removed from the
+// highest index down and the remaining indices stay valid.
+func removeIndices(basePath string, indices []int) (patch []patchOperation) {
+	sorted := append([]int(nil), indices...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	for i, idx := range sorted {
+		if i > 0 && idx == sorted[i-1] {
+			continue
+		}
+		patch = append(patch, patchOperation{Op: "remove", Path: jsonPointer(basePath, strconv.Itoa(idx))})
+	}
+	return patch
+}
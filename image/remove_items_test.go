@@ -0,0 +1,190 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestRemoveItems(t *testing.T) {
+	pod := testPod(t, `
+spec:
+  initContainers:
+    - name: migrate
+      image: flyway
+      env:
+        - name: DEBUG_SQL
+          value: "true"
+  containers:
+    - name: app
+      image: nginx
+      env:
+        - name: DEBUG
+          value: "true"
+        - name: REGION
+          value: westeurope
+        - name: DEBUG_PORT
+          value: "5005"
+        - name: TIER
+          value: web
+  tolerations:
+    - key: node.kubernetes.io/not-ready
+      operator: Exists
+      effect: NoExecute
+    - key: spot
+      operator: Exists
+      effect: NoSchedule
+    - key: spot
+      operator: Exists
+      effect: NoExecute
+    - key: gpu
+      operator: Exists
+  topologySpreadConstraints:
+    - maxSkew: 1
+      topologyKey: kubernetes.io/hostname
+      whenUnsatisfiable: ScheduleAnyway
+    - maxSkew: 1
+      topologyKey: topology.kubernetes.io/zone
+      whenUnsatisfiable: DoNotSchedule
+    - maxSkew: 2
+      topologyKey: kubernetes.io/hostname
+      whenUnsatisfiable: DoNotSchedule
+  affinity:
+    nodeAffinity:
+      requiredDuringSchedulingIgnoredDuringExecution:
+        nodeSelectorTerms:
+          - matchExpressions:
+              - key: node.example.com/pool
+                operator: In
+                values: [general]
+          - matchExpressions:
+              - key: kubernetes.io/arch
+                operator: In
+                values: [amd64]
+      preferredDuringSchedulingIgnoredDuringExecution:
+        - weight: 10
+          preference:
+            matchExpressions:
+              - key: node.example.com/pool
+                operator: In
+                values: [general]
+    podAntiAffinity:
+      requiredDuringSchedulingIgnoredDuringExecution:
+        - topologyKey: kubernetes.io/hostname
+          labelSelector:
+            matchLabels:
+              app: checkout
+      preferredDuringSchedulingIgnoredDuringExecution:
+        - weight: 100
+          podAffinityTerm:
+            topologyKey: topology.kubernetes.io/zone
+            labelSelector:
+              matchLabels:
+                app: checkout
+        - weight: 50
+          podAffinityTerm:
+            topologyKey: kubernetes.io/hostname
+            labelSelector:
+              matchLabels:
+                app: checkout
+`)
+	cfg := testConfig(t, `
+remove:
+  env:
+    - nameRegex: ^DEBUG
+  tolerations:
+    - key: spot
+    - key: node.kubernetes.io/not-ready
+      effect: NoSchedule
+  topologyConstraintKeys: [kubernetes.io/hostname]
+  nodeAffinityKeys: [node.example.com/pool]
+  podAntiAffinityTopologyKeys: [kubernetes.io/hostname]
+`)
+
+	patched, _ := mustMutateTestPod(t, pod, cfg)
+	spec := patched.Spec
+
+	if got := envNames(spec.InitContainers[0]); !equalStrings(got, []string{"DEBUG_SQL"}) {
+		t.Errorf("init container env: got %v, want the default container types only", got)
+	}
+	if got := envNames(spec.Containers[0]); !equalStrings(got, []string{"REGION", "TIER"}) {
+		t.Errorf("got env %v, want [REGION TIER]", got)
+	}
+
+	var tolerations []string
+	for _, toleration := range spec.Tolerations {
+		tolerations = append(tolerations, toleration.Key+"/"+string(toleration.Effect))
+	}
+	if want := []string{"node.kubernetes.io/not-ready/NoExecute", "gpu/"}; !equalStrings(tolerations, want) {
+		t.Errorf("got tolerations %v, want %v", tolerations, want)
+	}
+
+	if len(spec.TopologySpreadConstraints) != 1 || spec.TopologySpreadConstraints[0].TopologyKey != "topology.kubernetes.io/zone" {
+		t.Errorf("got topology spread constraints %+v, want the zone constraint only", spec.TopologySpreadConstraints)
+	}
+
+	nodeAffinity := spec.Affinity.NodeAffinity
+	terms := nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 1 || terms[0].MatchExpressions[0].Key != "kubernetes.io/arch" {
+		t.Errorf("got required node affinity terms %+v, want the arch term only", terms)
+	}
+	if len(nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution) != 0 {
+		t.Errorf("got preferred node affinity terms %+v, want none", nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution)
+	}
+
+	podAntiAffinity := spec.Affinity.PodAntiAffinity
+	if len(podAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution) != 0 {
+		t.Errorf("got required pod anti-affinity terms %+v, want none", podAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution)
+	}
+	preferred := podAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	if len(preferred) != 1 || preferred[0].Weight != 100 {
+		t.Errorf("got preferred pod anti-affinity terms %+v, want the zone term only", preferred)
+	}
+}
+
+func TestRemoveAllRequiredNodeAffinityTerms(t *testing.T) {
+	pod := testPod(t, `
+spec:
+  containers:
+    - name: app
+      image: nginx
+  affinity:
+    nodeAffinity:
+      requiredDuringSchedulingIgnoredDuringExecution:
+        nodeSelectorTerms:
+          - matchExpressions:
+              - key: node.example.com/pool
+                operator: In
+                values: [general]
+`)
+	cfg := testConfig(t, `
+remove:
+  nodeAffinityKeys: [node.example.com/pool]
+`)
+
+	patched, _ := mustMutateTestPod(t, pod, cfg)
+	if required := patched.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution; required != nil {
+		t.Errorf("got required node affinity %+v, want it removed", required)
+	}
+}
+
+func TestRemoveIndices(t *testing.T) {
+	patch := removeIndices("/spec/tolerations", []int{1, 4, 1, 2})
+	var got []string
+	for _, op := range patch {
+		got = append(got, op.Path)
+	}
+	want := []string{"/spec/tolerations/4", "/spec/tolerations/2", "/spec/tolerations/1"}
+	if !equalStrings(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// envNames returns the names of the env vars of a container in order
+func envNames(container corev1.Container) []string {
+	names := make([]string, 0, len(container.Env))
+	for _, env := range container.Env {
+		names = append(names, env.Name)
+	}
+	return names
+}
@@ -1,5 +1,45 @@
 package main
 
+import (
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodAntiAffinityMode selects how the pod anti-affinity terms are rewritten
+type PodAntiAffinityMode string
+
+const (
+	// PodAntiAffinityModeRemove drops the terms
+	PodAntiAffinityModeRemove PodAntiAffinityMode = "remove"
+	// PodAntiAffinityModePreferred turns required terms into preferred terms
+	PodAntiAffinityModePreferred PodAntiAffinityMode = "preferred"
+	// PodAntiAffinityModeTopologySpread replaces the terms with topology spread constraints
+	PodAntiAffinityModeTopologySpread PodAntiAffinityMode = "topologySpread"
+)
+
+const (
+	defaultPreferredWeight int32 = 100
+	defaultMaxSkew         int32 = 1
+)
+
+// PodAntiAffinityRewrite rewrites the pod anti-affinity of the pod, e.g. to pack pods onto spot
+// nodes while still spreading them across hosts
+type PodAntiAffinityRewrite struct {
+	Mode PodAntiAffinityMode `yaml:"mode" json:"mode"`
+	// TopologyKeys restricts the rewrite to the terms with these topology keys, otherwise every
+	// term is rewritten
+	TopologyKeys []string `yaml:"topologyKeys,omitempty" json:"topologyKeys,omitempty"`
+	// Weight of the preferred terms converted from required terms, defaults to 100
+	Weight int32 `yaml:"weight,omitempty" json:"weight,omitempty"`
+	// MaxSkew of the topology spread constraints replacing the terms, defaults to 1
+	MaxSkew int32 `yaml:"maxSkew,omitempty" json:"maxSkew,omitempty"`
+}
+
+// rewrites reports whether the term with the topology key is rewritten
+func (rw *PodAntiAffinityRewrite) rewrites(topologyKey string) bool {
+	return len(rw.TopologyKeys) == 0 || containsString(rw.TopologyKeys, topologyKey)
+}
+
 // removePodAntiAffinity performs the mutation(s) needed to remove podAntiAffinity
 func removePodAntiAffinity(basePath string) (patch []patchOperation) {
 	patch = append(patch, patchOperation{
@@ -9,3 +49,130 @@ func removePodAntiAffinity(basePath string) (patch []patchOperation) {
 
 	return patch
 }
+
+// rewritePodAntiAffinity performs the mutation(s) needed to rewrite the pod anti-affinity terms
+// with the mode. The remaining terms replace the whole podAntiAffinity, so no index is involved.
+func rewritePodAntiAffinity(mc *mutationContext, spec *corev1.PodSpec, rw *PodAntiAffinityRewrite, basePath string) (patch []patchOperation) {
+	antiAffinity := spec.Affinity.PodAntiAffinity
+	if rw.Mode == PodAntiAffinityModeRemove && len(rw.TopologyKeys) == 0 {
+		structuredLog(LogLevelInfo, "PodAntiAffinity", "Removing pod anti-affinity")
+		return removePodAntiAffinity(basePath)
+	}
+
+	weight := rw.Weight
+	if weight == 0 {
+		weight = defaultPreferredWeight
+	}
+
+	var rewritten corev1.PodAntiAffinity
+	var converted []corev1.WeightedPodAffinityTerm
+	var constraints []corev1.TopologySpreadConstraint
+	// a pod may declare a single constraint per topology key and whenUnsatisfiable, so the
+	// converted constraints are checked against those of the pod and each other
+	spread := append([]corev1.TopologySpreadConstraint(nil), spec.TopologySpreadConstraints...)
+	convert := func(term corev1.PodAffinityTerm, whenUnsatisfiable corev1.UnsatisfiableConstraintAction) bool {
+		constraint, ok := antiAffinitySpreadConstraint(mc, term, rw.MaxSkew, whenUnsatisfiable)
+		if !ok {
+			return false
+		}
+		if idx := spreadConstraintIndex(spread, constraint.TopologyKey, whenUnsatisfiable); idx >= 0 {
+			if !cmp.Equal(spread[idx], constraint) {
+				mc.warn("pod anti-affinity term with topology key %s collides with another topology spread constraint with whenUnsatisfiable %s, it was kept", term.TopologyKey, whenUnsatisfiable)
+				return false
+			}
+			structuredLog(LogLevelDebug, "PodAntiAffinity", "Skipping existing topology spread constraint with topology key: %s", constraint.TopologyKey)
+			return true
+		}
+		spread = append(spread, constraint)
+		constraints = append(constraints, constraint)
+		return true
+	}
+	for _, term := range antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+		if !rw.rewrites(term.TopologyKey) {
+			rewritten.RequiredDuringSchedulingIgnoredDuringExecution = append(rewritten.RequiredDuringSchedulingIgnoredDuringExecution, term)
+			continue
+		}
+		switch rw.Mode {
+		case PodAntiAffinityModeRemove:
+			structuredLog(LogLevelInfo, "PodAntiAffinity", "Removing required term with topology key: %s", term.TopologyKey)
+		case PodAntiAffinityModePreferred:
+			structuredLog(LogLevelInfo, "PodAntiAffinity", "Converting required term with topology key %s to preferred with weight %d", term.TopologyKey, weight)
+			converted = append(converted, corev1.WeightedPodAffinityTerm{Weight: weight, PodAffinityTerm: term})
+		case PodAntiAffinityModeTopologySpread:
+			if !convert(term, corev1.DoNotSchedule) {
+				rewritten.RequiredDuringSchedulingIgnoredDuringExecution = append(rewritten.RequiredDuringSchedulingIgnoredDuringExecution, term)
+			}
+		}
+	}
+	for _, term := range antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		if !rw.rewrites(term.PodAffinityTerm.TopologyKey) || rw.Mode == PodAntiAffinityModePreferred {
+			rewritten.PreferredDuringSchedulingIgnoredDuringExecution = append(rewritten.PreferredDuringSchedulingIgnoredDuringExecution, term)
+			continue
+		}
+		switch rw.Mode {
+		case PodAntiAffinityModeRemove:
+			structuredLog(LogLevelInfo, "PodAntiAffinity", "Removing preferred term with topology key: %s", term.PodAffinityTerm.TopologyKey)
+		case PodAntiAffinityModeTopologySpread:
+			if !convert(term.PodAffinityTerm, corev1.ScheduleAnyway) {
+				rewritten.PreferredDuringSchedulingIgnoredDuringExecution = append(rewritten.PreferredDuringSchedulingIgnoredDuringExecution, term)
+			}
+		}
+	}
+	rewritten.PreferredDuringSchedulingIgnoredDuringExecution = append(rewritten.PreferredDuringSchedulingIgnoredDuringExecution, converted...)
+
+	switch {
+	case len(rewritten.RequiredDuringSchedulingIgnoredDuringExecution) == 0 && len(rewritten.PreferredDuringSchedulingIgnoredDuringExecution) == 0:
+		patch = append(patch, removePodAntiAffinity(basePath)...)
+	case !cmp.Equal(rewritten, *antiAffinity):
+		patch = append(patch, patchOperation{Op: "replace", Path: basePath, Value: rewritten})
+	}
+	return append(patch, addAntiAffinitySpreadConstraints(spec.TopologySpreadConstraints, constraints, "/spec/topologySpreadConstraints")...)
+}
+
+// antiAffinitySpreadConstraint returns the topology spread constraint spreading the pods the
+// anti-affinity term keeps apart. Spread constraints only count pods of the namespace of the pod,
+// so terms selecting other namespaces cannot be converted and are kept with a warning.
+func antiAffinitySpreadConstraint(mc *mutationContext, term corev1.PodAffinityTerm, maxSkew int32, whenUnsatisfiable corev1.UnsatisfiableConstraintAction) (corev1.TopologySpreadConstraint, bool) {
+	if len(term.Namespaces) > 0 || term.NamespaceSelector != nil || len(term.MismatchLabelKeys) > 0 {
+		mc.warn("pod anti-affinity term with topology key %s selects pods of other namespaces or uses mismatchLabelKeys, it was kept", term.TopologyKey)
+		return corev1.TopologySpreadConstraint{}, false
+	}
+	if maxSkew == 0 {
+		maxSkew = defaultMaxSkew
+	}
+
+	structuredLog(LogLevelInfo, "PodAntiAffinity", "Converting term with topology key %s to topology spread constraint (%s)", term.TopologyKey, whenUnsatisfiable)
+	return corev1.TopologySpreadConstraint{
+		MaxSkew:           maxSkew,
+		TopologyKey:       term.TopologyKey,
+		WhenUnsatisfiable: whenUnsatisfiable,
+		LabelSelector:     term.LabelSelector,
+		MatchLabelKeys:    term.MatchLabelKeys,
+	}, true
+}
+
+// spreadConstraintIndex returns the index of the constraint with the topology key and
+// whenUnsatisfiable, or -1
+func spreadConstraintIndex(constraints []corev1.TopologySpreadConstraint, topologyKey string, whenUnsatisfiable corev1.UnsatisfiableConstraintAction) int {
+	for idx, constraint := range constraints {
+		if constraint.TopologyKey == topologyKey && constraint.WhenUnsatisfiable == whenUnsatisfiable {
+			return idx
+		}
+	}
+	return -1
+}
+
+// addAntiAffinitySpreadConstraints performs the mutation(s) needed to add the converted
+// constraints
+func addAntiAffinitySpreadConstraints(target []corev1.TopologySpreadConstraint, constraints []corev1.TopologySpreadConstraint, basePath string) (patch []patchOperation) {
+	first := target == nil
+	for _, constraint := range constraints {
+		if first {
+			first = false
+			patch = append(patch, patchOperation{Op: "add", Path: basePath, Value: []corev1.TopologySpreadConstraint{constraint}})
+		} else {
+			patch = append(patch, patchOperation{Op: "add", Path: jsonPointer(basePath, "-"), Value: constraint})
+		}
+	}
+	return patch
+}
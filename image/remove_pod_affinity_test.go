@@ -0,0 +1,232 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// antiAffinityTerms formats the pod anti-affinity terms, e.g. "required:kubernetes.io/hostname"
+// or "preferred/100:topology.kubernetes.io/zone"
+func antiAffinityTerms(spec corev1.PodSpec) []string {
+	terms := []string{}
+	if spec.Affinity == nil || spec.Affinity.PodAntiAffinity == nil {
+		return terms
+	}
+	for _, term := range spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+		terms = append(terms, "required:"+term.TopologyKey)
+	}
+	for _, term := range spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		terms = append(terms, "preferred/"+strconv.Itoa(int(term.Weight))+":"+term.PodAffinityTerm.TopologyKey)
+	}
+	return terms
+}
+
+// spreadConstraints formats the topology spread constraints, e.g.
+// "kubernetes.io/hostname/DoNotSchedule/1", sorted
+func spreadConstraints(spec corev1.PodSpec) []string {
+	constraints := []string{}
+	for _, constraint := range spec.TopologySpreadConstraints {
+		constraints = append(constraints, constraint.TopologyKey+"/"+string(constraint.WhenUnsatisfiable)+"/"+strconv.Itoa(int(constraint.MaxSkew)))
+	}
+	sort.Strings(constraints)
+	return constraints
+}
+
+func TestRewritePodAntiAffinity(t *testing.T) {
+	pod := `
+spec:
+  containers:
+    - name: app
+      image: nginx
+  affinity:
+    podAntiAffinity:
+      requiredDuringSchedulingIgnoredDuringExecution:
+        - topologyKey: kubernetes.io/hostname
+          labelSelector:
+            matchLabels:
+              app: checkout
+      preferredDuringSchedulingIgnoredDuringExecution:
+        - weight: 50
+          podAffinityTerm:
+            topologyKey: topology.kubernetes.io/zone
+            labelSelector:
+              matchLabels:
+                app: checkout
+`
+
+	tests := []struct {
+		name        string
+		pod         string
+		config      string
+		terms       []string
+		constraints []string
+		warns       int
+	}{
+		{
+			name: "removePodAntiAffinity",
+			pod:  pod,
+			config: `
+removePodAntiAffinity: true
+`,
+			terms:       []string{},
+			constraints: []string{},
+		},
+		{
+			name: "remove by topology key",
+			pod:  pod,
+			config: `
+podAntiAffinity:
+  mode: remove
+  topologyKeys: [topology.kubernetes.io/zone]
+`,
+			terms:       []string{"required:kubernetes.io/hostname"},
+			constraints: []string{},
+		},
+		{
+			name: "preferred",
+			pod:  pod,
+			config: `
+podAntiAffinity:
+  mode: preferred
+  weight: 80
+`,
+			terms:       []string{"preferred/50:topology.kubernetes.io/zone", "preferred/80:kubernetes.io/hostname"},
+			constraints: []string{},
+		},
+		{
+			name: "topology spread",
+			pod:  pod,
+			config: `
+podAntiAffinity:
+  mode: topologySpread
+  maxSkew: 2
+`,
+			terms:       []string{},
+			constraints: []string{"kubernetes.io/hostname/DoNotSchedule/2", "topology.kubernetes.io/zone/ScheduleAnyway/2"},
+		},
+		{
+			name: "topology spread colliding with an existing constraint",
+			pod: pod + `
+  topologySpreadConstraints:
+    - maxSkew: 3
+      topologyKey: kubernetes.io/hostname
+      whenUnsatisfiable: DoNotSchedule
+      labelSelector:
+        matchLabels:
+          app: checkout
+`,
+			config: `
+podAntiAffinity:
+  mode: topologySpread
+`,
+			terms:       []string{"required:kubernetes.io/hostname"},
+			constraints: []string{"kubernetes.io/hostname/DoNotSchedule/3", "topology.kubernetes.io/zone/ScheduleAnyway/1"},
+			warns:       1,
+		},
+		{
+			name: "topology spread colliding with an injected constraint",
+			pod:  pod,
+			config: `
+topologyConstraints:
+  - maxSkew: 1
+    topologyKey: topology.kubernetes.io/zone
+    whenUnsatisfiable: ScheduleAnyway
+    labelSelector:
+      matchLabels:
+        tier: web
+podAntiAffinity:
+  mode: topologySpread
+`,
+			terms:       []string{"preferred/50:topology.kubernetes.io/zone"},
+			constraints: []string{"kubernetes.io/hostname/DoNotSchedule/1", "topology.kubernetes.io/zone/ScheduleAnyway/1"},
+			warns:       1,
+		},
+		{
+			name: "topology spread with the same constraint",
+			pod: pod + `
+  topologySpreadConstraints:
+    - maxSkew: 1
+      topologyKey: kubernetes.io/hostname
+      whenUnsatisfiable: DoNotSchedule
+      labelSelector:
+        matchLabels:
+          app: checkout
+`,
+			config: `
+podAntiAffinity:
+  mode: topologySpread
+`,
+			terms:       []string{},
+			constraints: []string{"kubernetes.io/hostname/DoNotSchedule/1", "topology.kubernetes.io/zone/ScheduleAnyway/1"},
+		},
+		{
+			name: "topology spread terms colliding with each other",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+  affinity:
+    podAntiAffinity:
+      requiredDuringSchedulingIgnoredDuringExecution:
+        - topologyKey: kubernetes.io/hostname
+          labelSelector:
+            matchLabels:
+              app: checkout
+        - topologyKey: kubernetes.io/hostname
+          labelSelector:
+            matchLabels:
+              app: cart
+`,
+			config: `
+podAntiAffinity:
+  mode: topologySpread
+`,
+			terms:       []string{"required:kubernetes.io/hostname"},
+			constraints: []string{"kubernetes.io/hostname/DoNotSchedule/1"},
+			warns:       1,
+		},
+		{
+			name: "terms selecting other namespaces",
+			pod: `
+spec:
+  containers:
+    - name: app
+      image: nginx
+  affinity:
+    podAntiAffinity:
+      requiredDuringSchedulingIgnoredDuringExecution:
+        - topologyKey: kubernetes.io/hostname
+          namespaces: [billing]
+          labelSelector:
+            matchLabels:
+              app: checkout
+`,
+			config: `
+podAntiAffinity:
+  mode: topologySpread
+`,
+			terms:       []string{"required:kubernetes.io/hostname"},
+			constraints: []string{},
+			warns:       1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patched, mc := mustMutateTestPod(t, testPod(t, tt.pod), testConfig(t, tt.config))
+			if got := antiAffinityTerms(patched.Spec); !equalStrings(got, tt.terms) {
+				t.Errorf("got anti-affinity terms %v, want %v", got, tt.terms)
+			}
+			if got := spreadConstraints(patched.Spec); !equalStrings(got, tt.constraints) {
+				t.Errorf("got spread constraints %v, want %v", got, tt.constraints)
+			}
+			if len(mc.warnings) != tt.warns {
+				t.Errorf("got warnings %q, want %d", mc.warnings, tt.warns)
+			}
+		})
+	}
+}
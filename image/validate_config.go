@@ -0,0 +1,950 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	yamlv3 "sigs.k8s.io/yaml/goyaml.v3"
+)
+
+var (
+	supportedTolerationOperators     = sets.New(string(corev1.TolerationOpEqual), string(corev1.TolerationOpExists))
+	supportedTaintEffects            = sets.New(string(corev1.TaintEffectNoSchedule), string(corev1.TaintEffectPreferNoSchedule), string(corev1.TaintEffectNoExecute))
+	supportedScheduleActions         = sets.New(string(corev1.DoNotSchedule), string(corev1.ScheduleAnyway))
+	supportedPodTopologySpreadPolicy = sets.New(string(corev1.NodeInclusionPolicyHonor), string(corev1.NodeInclusionPolicyIgnore))
+	supportedNodeSelectorOperators   = sets.New(string(corev1.NodeSelectorOpIn), string(corev1.NodeSelectorOpNotIn),
+		string(corev1.NodeSelectorOpExists), string(corev1.NodeSelectorOpDoesNotExist), string(corev1.NodeSelectorOpGt), string(corev1.NodeSelectorOpLt))
+	supportedNodeFieldSelectorOperators = sets.New(string(corev1.NodeSelectorOpIn), string(corev1.NodeSelectorOpNotIn))
+	supportedMergeStrategies            = sets.New(string(MergeStrategyOverride), string(MergeStrategyKeepExisting), string(MergeStrategyFail))
+	supportedPreemptionPolicies         = sets.New(string(corev1.PreemptLowerPriority), string(corev1.PreemptNever))
+	supportedSeccompProfileTypes        = sets.New(string(corev1.SeccompProfileTypeRuntimeDefault), string(corev1.SeccompProfileTypeLocalhost))
+	supportedPodAntiAffinityModes       = sets.New(string(PodAntiAffinityModeRemove), string(PodAntiAffinityModePreferred), string(PodAntiAffinityModeTopologySpread))
+	supportedDNSPolicies                = sets.New(string(corev1.DNSClusterFirstWithHostNet), string(corev1.DNSClusterFirst), string(corev1.DNSDefault), string(corev1.DNSNone))
+	supportedDNSListPositions           = sets.New(string(DNSListPositionAppend), string(DNSListPositionPrepend))
+	supportedContainerTypes             = sets.New(string(ContainerTypeContainers), string(ContainerTypeInitContainers), string(ContainerTypeEphemeralContainers))
+
+	strictFieldPattern = regexp.MustCompile(`^(?:unknown|duplicate) field "([^"]+)"$`)
+)
+
+// validateEnvConfig performs the semantic validation of every profile. The rules follow
+// the Kubernetes API validation of the corresponding pod fields.
+func validateEnvConfig(cfg *EnvConfig) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if cfg.legacy {
+		// a legacy file holds the fields of its single profile at the top level
+		return validateConfig(&cfg.Profiles[0].Config, nil)
+	}
+
+	seen := sets.New[string]()
+	for i := range cfg.Profiles {
+		profile := &cfg.Profiles[i]
+		idxPath := field.NewPath("profiles").Index(i)
+		if profile.Name == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("name"), ""))
+		} else if seen.Has(profile.Name) {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("name"), profile.Name))
+		}
+		seen.Insert(profile.Name)
+		allErrs = append(allErrs, validateConfig(&profile.Config, idxPath)...)
+	}
+	return allErrs
+}
+
+// validateConfig validates the mutation blocks of a single profile or policy spec
+func validateConfig(cfg *Config, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	for i, env := range cfg.Env {
+		allErrs = append(allErrs, validateEnvVar(env, fldPath.Child("env").Index(i))...)
+	}
+	for i, source := range cfg.EnvFrom {
+		allErrs = append(allErrs, validateEnvFromSource(source, fldPath.Child("envFrom").Index(i))...)
+	}
+	volumeNames := sets.New[string]()
+	for i, volume := range cfg.Volumes {
+		idxPath := fldPath.Child("volumes").Index(i)
+		allErrs = append(allErrs, validateVolume(volume.Volume, idxPath)...)
+		if volumeNames.Has(volume.Name) {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("name"), volume.Name))
+		}
+		volumeNames.Insert(volume.Name)
+		allErrs = append(allErrs, validateMergeStrategy(volume.MergeStrategy, idxPath.Child("mergeStrategy"))...)
+	}
+	// a container may only mount one volume at a path, whatever containers the mounts target
+	mountPaths := sets.New[string]()
+	for i, mount := range cfg.VolumeMounts {
+		idxPath := fldPath.Child("volumeMounts").Index(i)
+		allErrs = append(allErrs, validateVolumeMount(mount.VolumeMount, idxPath)...)
+		if mountPaths.Has(mount.MountPath) {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("mountPath"), mount.MountPath))
+		}
+		mountPaths.Insert(mount.MountPath)
+		allErrs = append(allErrs, validateContainerTarget(mount.ContainerTarget, idxPath)...)
+		allErrs = append(allErrs, validateMergeStrategy(mount.MergeStrategy, idxPath.Child("mergeStrategy"))...)
+	}
+	for i, opt := range cfg.DnsOptions {
+		idxPath := fldPath.Child("dnsOptions").Index(i)
+		if opt.Name == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("name"), ""))
+		}
+		allErrs = append(allErrs, validateMergeStrategy(opt.MergeStrategy, idxPath.Child("mergeStrategy"))...)
+	}
+	allErrs = append(allErrs, validateDNSList(cfg.DnsNameservers, validateIPAddress, dnsNameserversLimit, fldPath.Child("dnsNameservers"))...)
+	allErrs = append(allErrs, validateDNSList(cfg.DnsSearches, validateSearchDomain, dnsSearchesLimit, fldPath.Child("dnsSearches"))...)
+	allErrs = append(allErrs, validateSchedulingField(cfg.DnsPolicy, nil, fldPath.Child("dnsPolicy"))...)
+	if cfg.DnsPolicy != nil && cfg.DnsPolicy.Value != "" {
+		if !supportedDNSPolicies.Has(cfg.DnsPolicy.Value) {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Child("dnsPolicy", "value"), cfg.DnsPolicy.Value, sets.List(supportedDNSPolicies)))
+		}
+		if cfg.DnsPolicy.Value == string(corev1.DNSNone) && (cfg.DnsNameservers == nil || len(cfg.DnsNameservers.Values) == 0) {
+			allErrs = append(allErrs, field.Required(fldPath.Child("dnsNameservers"), "must provide at least one DNS nameserver when dnsPolicy is None"))
+		}
+	}
+	hostAliasIPs := sets.New[string]()
+	for i, hostAlias := range cfg.HostAliases {
+		idxPath := fldPath.Child("hostAliases").Index(i)
+		allErrs = append(allErrs, validateIPAddress(hostAlias.IP, idxPath.Child("ip"))...)
+		if hostAliasIPs.Has(hostAlias.IP) {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("ip"), hostAlias.IP))
+		}
+		hostAliasIPs.Insert(hostAlias.IP)
+		for j, hostname := range hostAlias.Hostnames {
+			for _, msg := range validation.IsDNS1123Subdomain(hostname) {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("hostnames").Index(j), hostname, msg))
+			}
+		}
+		allErrs = append(allErrs, validateMergeStrategy(hostAlias.MergeStrategy, idxPath.Child("mergeStrategy"))...)
+	}
+	for i, term := range cfg.RequiredNodeAffinityTerms {
+		allErrs = append(allErrs, validateNodeSelectorTerm(term, fldPath.Child("requiredNodeAffinityTerms").Index(i))...)
+	}
+	for i, term := range cfg.PreferredNodeAffinityTerms {
+		idxPath := fldPath.Child("preferredNodeAffinityTerms").Index(i)
+		for _, msg := range validation.IsInRange(int(term.Weight), 1, 100) {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("weight"), term.Weight, msg))
+		}
+		allErrs = append(allErrs, validateNodeSelectorTerm(term.Preference, idxPath.Child("preference"))...)
+	}
+	sidecarNames := sets.New[string]()
+	for i, sidecar := range cfg.Sidecars {
+		idxPath := fldPath.Child("sidecars").Index(i)
+		allErrs = append(allErrs, validateSidecar(sidecar, idxPath)...)
+		if sidecarNames.Has(sidecar.Name) {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("name"), sidecar.Name))
+		}
+		sidecarNames.Insert(sidecar.Name)
+	}
+	if cfg.Resources != nil {
+		allErrs = append(allErrs, validateResourceDefaults(cfg.Resources, fldPath.Child("resources"))...)
+	}
+	allErrs = append(allErrs, validateLabels(cfg.Labels, fldPath.Child("labels"))...)
+	allErrs = append(allErrs, validateAnnotations(cfg.Annotations, fldPath.Child("annotations"))...)
+	allErrs = append(allErrs, validateLabels(cfg.NodeSelector, fldPath.Child("nodeSelector"))...)
+	if reason, conflict := nodeSelectorConflict(cfg.NodeSelector, cfg.RequiredNodeAffinityTerms); conflict {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("nodeSelector"), "", reason))
+	}
+	allErrs = append(allErrs, validateSchedulingField(cfg.PriorityClassName, validation.IsDNS1123Subdomain, fldPath.Child("priorityClassName"))...)
+	allErrs = append(allErrs, validateSchedulingField(cfg.SchedulerName, validation.IsDNS1123Subdomain, fldPath.Child("schedulerName"))...)
+	allErrs = append(allErrs, validateSchedulingField(cfg.RuntimeClassName, validation.IsDNS1123Subdomain, fldPath.Child("runtimeClassName"))...)
+	allErrs = append(allErrs, validateSchedulingField(cfg.PreemptionPolicy, nil, fldPath.Child("preemptionPolicy"))...)
+	if cfg.PreemptionPolicy != nil && cfg.PreemptionPolicy.Value != "" && !supportedPreemptionPolicies.Has(cfg.PreemptionPolicy.Value) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("preemptionPolicy", "value"), cfg.PreemptionPolicy.Value, sets.List(supportedPreemptionPolicies)))
+	}
+	if cfg.SchedulingGates != nil {
+		allErrs = append(allErrs, validateSchedulingGates(cfg.SchedulingGates, fldPath.Child("schedulingGates"))...)
+	}
+	if cfg.SecurityContext != nil {
+		allErrs = append(allErrs, validateSecurityContextDefaults(cfg.SecurityContext, fldPath.Child("securityContext"))...)
+	}
+	pullSecretNames := sets.New[string]()
+	for i, secret := range cfg.ImagePullSecrets {
+		idxPath := fldPath.Child("imagePullSecrets").Index(i)
+		allErrs = append(allErrs, validateObjectName(secret.Name, idxPath.Child("name"))...)
+		if pullSecretNames.Has(secret.Name) {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("name"), secret.Name))
+		}
+		pullSecretNames.Insert(secret.Name)
+	}
+	if cfg.ImageRewrite != nil {
+		allErrs = append(allErrs, validateImageRewrite(cfg.ImageRewrite, fldPath.Child("imageRewrite"))...)
+	}
+	for i, toleration := range cfg.Tolerations {
+		idxPath := fldPath.Child("tolerations").Index(i)
+		allErrs = append(allErrs, validateToleration(toleration.Toleration, idxPath)...)
+		allErrs = append(allErrs, validateMergeStrategy(toleration.MergeStrategy, idxPath.Child("mergeStrategy"))...)
+	}
+	// a pod may declare a single constraint per topology key and whenUnsatisfiable
+	constraintPairs := sets.New[string]()
+	for i, constraint := range cfg.TopologyConstraints {
+		idxPath := fldPath.Child("topologyConstraints").Index(i)
+		allErrs = append(allErrs, validateTopologySpreadConstraint(constraint.TopologySpreadConstraint, idxPath)...)
+		allErrs = append(allErrs, validateMergeStrategy(constraint.MergeStrategy, idxPath.Child("mergeStrategy"))...)
+		pair := fmt.Sprintf("{%s, %s}", constraint.TopologyKey, constraint.WhenUnsatisfiable)
+		if constraintPairs.Has(pair) {
+			allErrs = append(allErrs, field.Duplicate(idxPath, pair))
+		}
+		constraintPairs.Insert(pair)
+	}
+	if cfg.PodAntiAffinity != nil {
+		allErrs = append(allErrs, validatePodAntiAffinityRewrite(cfg.PodAntiAffinity, fldPath.Child("podAntiAffinity"))...)
+		if cfg.RemovePodAntiAffinity {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("removePodAntiAffinity"), true, "may not be set together with podAntiAffinity, use mode remove instead"))
+		}
+	}
+	allErrs = append(allErrs, validatePodAffinityTerms(cfg.PodAffinityTerms, fldPath.Child("podAffinityTerms"))...)
+	allErrs = append(allErrs, validatePodAffinityTerms(cfg.PodAntiAffinityTerms, fldPath.Child("podAntiAffinityTerms"))...)
+	if cfg.Remove != nil {
+		allErrs = append(allErrs, validateRemoveRules(cfg.Remove, fldPath.Child("remove"))...)
+	}
+	if cfg.PodSelector != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(cfg.PodSelector,
+			metav1validation.LabelSelectorValidationOptions{}, fldPath.Child("podSelector"))...)
+	}
+	allErrs = append(allErrs, validateContainerSelector(cfg.ContainerSelector, fldPath.Child("containerSelector"))...)
+
+	strategiesPath := fldPath.Child("mergeStrategies")
+	allErrs = append(allErrs, validateMergeStrategy(cfg.MergeStrategies.Env, strategiesPath.Child("env"))...)
+	allErrs = append(allErrs, validateMergeStrategy(cfg.MergeStrategies.EnvFrom, strategiesPath.Child("envFrom"))...)
+	allErrs = append(allErrs, validateMergeStrategy(cfg.MergeStrategies.DnsOptions, strategiesPath.Child("dnsOptions"))...)
+	allErrs = append(allErrs, validateMergeStrategy(cfg.MergeStrategies.Tolerations, strategiesPath.Child("tolerations"))...)
+	allErrs = append(allErrs, validateMergeStrategy(cfg.MergeStrategies.TopologyConstraints, strategiesPath.Child("topologyConstraints"))...)
+	allErrs = append(allErrs, validateMergeStrategy(cfg.MergeStrategies.Volumes, strategiesPath.Child("volumes"))...)
+	allErrs = append(allErrs, validateMergeStrategy(cfg.MergeStrategies.VolumeMounts, strategiesPath.Child("volumeMounts"))...)
+	allErrs = append(allErrs, validateMergeStrategy(cfg.MergeStrategies.Sidecars, strategiesPath.Child("sidecars"))...)
+	allErrs = append(allErrs, validateMergeStrategy(cfg.MergeStrategies.Labels, strategiesPath.Child("labels"))...)
+	allErrs = append(allErrs, validateMergeStrategy(cfg.MergeStrategies.Annotations, strategiesPath.Child("annotations"))...)
+	allErrs = append(allErrs, validateMergeStrategy(cfg.MergeStrategies.NodeSelector, strategiesPath.Child("nodeSelector"))...)
+	allErrs = append(allErrs, validateMergeStrategy(cfg.MergeStrategies.SecurityContext, strategiesPath.Child("securityContext"))...)
+	allErrs = append(allErrs, validateMergeStrategy(cfg.MergeStrategies.HostAliases, strategiesPath.Child("hostAliases"))...)
+
+	return allErrs
+}
+
+func validateEnvVar(envVar EnvVar, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	env := envVar.EnvVar
+
+	if env.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("name"), ""))
+	} else {
+		for _, msg := range validation.IsEnvVarName(env.Name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("name"), env.Name, msg))
+		}
+	}
+
+	if env.ValueFrom != nil {
+		if env.Value != "" {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("valueFrom"), "", "may not be specified when `value` is not empty"))
+		}
+		sources := 0
+		for _, set := range []bool{env.ValueFrom.FieldRef != nil, env.ValueFrom.ResourceFieldRef != nil,
+			env.ValueFrom.ConfigMapKeyRef != nil, env.ValueFrom.SecretKeyRef != nil} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("valueFrom"), "",
+				"must specify exactly one of: `fieldRef`, `resourceFieldRef`, `configMapKeyRef` or `secretKeyRef`"))
+		}
+	}
+
+	if isEnvTemplate(env.Value) {
+		if _, err := parseEnvTemplate(env.Name, env.Value); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("value"), env.Value, err.Error()))
+		}
+	} else if envVar.Default != nil {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("default"), "may only be specified when `value` is a template"))
+	}
+	allErrs = append(allErrs, validateContainerTarget(envVar.ContainerTarget, fldPath)...)
+	allErrs = append(allErrs, validateMergeStrategy(envVar.MergeStrategy, fldPath.Child("mergeStrategy"))...)
+	return allErrs
+}
+
+func validateEnvFromSource(source EnvFromSource, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if source.Prefix != "" {
+		for _, msg := range validation.IsEnvVarName(source.Prefix) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("prefix"), source.Prefix, msg))
+		}
+	}
+
+	switch {
+	case source.ConfigMapRef != nil && source.SecretRef != nil:
+		allErrs = append(allErrs, field.Invalid(fldPath, "", "may not have more than one field specified at a time"))
+	case source.ConfigMapRef != nil:
+		allErrs = append(allErrs, validateObjectName(source.ConfigMapRef.Name, fldPath.Child("configMapRef", "name"))...)
+	case source.SecretRef != nil:
+		allErrs = append(allErrs, validateObjectName(source.SecretRef.Name, fldPath.Child("secretRef", "name"))...)
+	default:
+		allErrs = append(allErrs, field.Invalid(fldPath, "", "must specify one of: `configMapRef` or `secretRef`"))
+	}
+
+	allErrs = append(allErrs, validateContainerTarget(source.ContainerTarget, fldPath)...)
+	allErrs = append(allErrs, validateMergeStrategy(source.MergeStrategy, fldPath.Child("mergeStrategy"))...)
+	return allErrs
+}
+
+func validateSidecar(sidecar Sidecar, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if sidecar.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("name"), ""))
+	} else {
+		for _, msg := range validation.IsDNS1123Label(sidecar.Name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("name"), sidecar.Name, msg))
+		}
+	}
+	if sidecar.Image == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("image"), ""))
+	}
+	if sidecar.RestartPolicy != nil {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("restartPolicy"), "use `nativeSidecar` to inject an init container with restartPolicy Always"))
+	}
+	if _, err := walkContainerTemplates(sidecar.Container, func(value string) (string, error) {
+		_, err := parseEnvTemplate(sidecar.Name, value)
+		return value, err
+	}); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath, sidecar.Name, err.Error()))
+	}
+	allErrs = append(allErrs, validateMergeStrategy(sidecar.MergeStrategy, fldPath.Child("mergeStrategy"))...)
+	return allErrs
+}
+
+func validateResourceDefaults(rd *ResourceDefaults, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	supported := sets.New[string]()
+	for _, name := range defaultedResources {
+		supported.Insert(string(name))
+	}
+
+	lists := []struct {
+		name string
+		list corev1.ResourceList
+	}{{"requests", rd.Requests}, {"limits", rd.Limits}, {"min", rd.Min}, {"max", rd.Max}}
+	for _, l := range lists {
+		for _, name := range sets.List(sets.KeySet(l.list)) {
+			quantity := l.list[name]
+			if !supported.Has(string(name)) {
+				allErrs = append(allErrs, field.NotSupported(fldPath.Child(l.name).Key(string(name)), name, sets.List(supported)))
+			} else if quantity.Sign() < 0 {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child(l.name).Key(string(name)), quantity.String(), "must be greater than or equal to 0"))
+			}
+		}
+	}
+	for _, name := range sets.List(sets.KeySet(rd.LimitRequestRatio)) {
+		ratioPath := fldPath.Child("limitRequestRatio").Key(string(name))
+		if !supported.Has(string(name)) {
+			allErrs = append(allErrs, field.NotSupported(ratioPath, name, sets.List(supported)))
+		} else if rd.LimitRequestRatio[name] < 1 {
+			allErrs = append(allErrs, field.Invalid(ratioPath, rd.LimitRequestRatio[name], "must be greater than or equal to 1"))
+		}
+	}
+	for _, name := range defaultedResources {
+		minimum, hasMin := rd.Min[name]
+		maximum, hasMax := rd.Max[name]
+		if hasMin && hasMax && minimum.Cmp(maximum) > 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("min").Key(string(name)), minimum.String(), "must be less than or equal to max"))
+		}
+		request, hasRequest := rd.Requests[name]
+		limit, hasLimit := rd.Limits[name]
+		if hasRequest && hasLimit && request.Cmp(limit) > 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("requests").Key(string(name)), request.String(), "must be less than or equal to limits"))
+		}
+	}
+
+	if containsContainerType(rd.ContainerTypes, ContainerTypeEphemeralContainers) {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("containerTypes"), "ephemeral containers may not have resources"))
+	}
+	allErrs = append(allErrs, validateContainerTarget(rd.ContainerTarget, fldPath)...)
+	return allErrs
+}
+
+func validateLabels(labels map[string]string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for _, key := range sets.List(sets.KeySet(labels)) {
+		for _, msg := range validation.IsQualifiedName(key) {
+			allErrs = append(allErrs, field.Invalid(fldPath, key, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(labels[key]) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(key), labels[key], msg))
+		}
+	}
+	return allErrs
+}
+
+func validateAnnotations(annotations map[string]string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for _, key := range sets.List(sets.KeySet(annotations)) {
+		for _, msg := range validation.IsQualifiedName(strings.ToLower(key)) {
+			allErrs = append(allErrs, field.Invalid(fldPath, key, msg))
+		}
+		if key == admissionWebhookAnnotationStatusKey {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Key(key), "is managed by the webhook"))
+		}
+	}
+	return allErrs
+}
+
+func validateSchedulingField(f *SchedulingField, validate func(string) []string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if f == nil {
+		return allErrs
+	}
+	if f.Value == "" {
+		return append(allErrs, field.Required(fldPath.Child("value"), ""))
+	}
+	if validate != nil {
+		for _, msg := range validate(f.Value) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("value"), f.Value, msg))
+		}
+	}
+	return allErrs
+}
+
+func validateSchedulingGates(gates *SchedulingGates, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if len(gates.Names) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath.Child("names"), ""))
+	}
+	seen := sets.New[string]()
+	for i, name := range gates.Names {
+		idxPath := fldPath.Child("names").Index(i)
+		for _, msg := range validation.IsQualifiedName(name) {
+			allErrs = append(allErrs, field.Invalid(idxPath, name, msg))
+		}
+		if seen.Has(name) {
+			allErrs = append(allErrs, field.Duplicate(idxPath, name))
+		}
+		seen.Insert(name)
+	}
+	return allErrs
+}
+
+func validateSecurityContextDefaults(sc *SecurityContextDefaults, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if profile := sc.SeccompProfile; profile != nil {
+		profilePath := fldPath.Child("seccompProfile")
+		if !supportedSeccompProfileTypes.Has(string(profile.Type)) {
+			// an Unconfined profile would not harden the pod
+			allErrs = append(allErrs, field.NotSupported(profilePath.Child("type"), profile.Type, sets.List(supportedSeccompProfileTypes)))
+		}
+		if profile.Type == corev1.SeccompProfileTypeLocalhost && (profile.LocalhostProfile == nil || *profile.LocalhostProfile == "") {
+			allErrs = append(allErrs, field.Required(profilePath.Child("localhostProfile"), "must be set when seccomp type is Localhost"))
+		}
+		if profile.Type != corev1.SeccompProfileTypeLocalhost && profile.LocalhostProfile != nil {
+			allErrs = append(allErrs, field.Invalid(profilePath.Child("localhostProfile"), *profile.LocalhostProfile, "can only be set when seccomp type is Localhost"))
+		}
+	}
+	for i, capability := range sc.DropCapabilities {
+		if capability == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("dropCapabilities").Index(i), ""))
+		}
+	}
+	if containsContainerType(sc.ContainerTypes, ContainerTypeEphemeralContainers) {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("containerTypes"), "ephemeral containers are added after admission of the pod"))
+	}
+	allErrs = append(allErrs, validateContainerTarget(sc.ContainerTarget, fldPath)...)
+
+	strategiesPath := fldPath.Child("mergeStrategies")
+	allErrs = append(allErrs, validateMergeStrategy(sc.MergeStrategies.RunAsNonRoot, strategiesPath.Child("runAsNonRoot"))...)
+	allErrs = append(allErrs, validateMergeStrategy(sc.MergeStrategies.SeccompProfile, strategiesPath.Child("seccompProfile"))...)
+	allErrs = append(allErrs, validateMergeStrategy(sc.MergeStrategies.AllowPrivilegeEscalation, strategiesPath.Child("allowPrivilegeEscalation"))...)
+	allErrs = append(allErrs, validateMergeStrategy(sc.MergeStrategies.ReadOnlyRootFilesystem, strategiesPath.Child("readOnlyRootFilesystem"))...)
+	return allErrs
+}
+
+func validateImageRewrite(rw *ImageRewrite, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if len(rw.Rules) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath.Child("rules"), ""))
+	}
+	for i, rule := range rw.Rules {
+		idxPath := fldPath.Child("rules").Index(i)
+		switch {
+		case rule.Prefix == "" && rule.Regex == "":
+			allErrs = append(allErrs, field.Required(idxPath, "must specify prefix or regex"))
+		case rule.Prefix != "" && rule.Regex != "":
+			allErrs = append(allErrs, field.Invalid(idxPath, "", "prefix and regex are mutually exclusive"))
+		case rule.Regex != "":
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("regex"), rule.Regex, err.Error()))
+			}
+			rw.Rules[i].regex = re
+		}
+		if rule.Replacement == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("replacement"), ""))
+		}
+	}
+	var errs field.ErrorList
+	rw.exclude, errs = validateImagePatterns(rw.Exclude, fldPath.Child("exclude"))
+	allErrs = append(allErrs, errs...)
+	return allErrs
+}
+
+func validatePodAntiAffinityRewrite(rw *PodAntiAffinityRewrite, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if !supportedPodAntiAffinityModes.Has(string(rw.Mode)) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("mode"), rw.Mode, sets.List(supportedPodAntiAffinityModes)))
+	}
+	for i, key := range rw.TopologyKeys {
+		allErrs = append(allErrs, metav1validation.ValidateLabelName(key, fldPath.Child("topologyKeys").Index(i))...)
+	}
+	if rw.Weight != 0 {
+		for _, msg := range validation.IsInRange(int(rw.Weight), 1, 100) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("weight"), rw.Weight, msg))
+		}
+	}
+	if rw.MaxSkew < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxSkew"), rw.MaxSkew, "must be greater than zero"))
+	}
+	return allErrs
+}
+
+func validatePodAffinityTerms(terms *PodAffinityTerms, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if terms == nil {
+		return allErrs
+	}
+
+	for i, term := range terms.Required {
+		allErrs = append(allErrs, validatePodAffinityTerm(term, fldPath.Child("required").Index(i))...)
+	}
+	for i, term := range terms.Preferred {
+		idxPath := fldPath.Child("preferred").Index(i)
+		for _, msg := range validation.IsInRange(int(term.Weight), 1, 100) {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("weight"), term.Weight, msg))
+		}
+		allErrs = append(allErrs, validatePodAffinityTerm(term.PodAffinityTerm, idxPath.Child("podAffinityTerm"))...)
+	}
+	return allErrs
+}
+
+// validatePodAffinityTerm validates a term with its templates replaced by a placeholder, as
+// they are only expanded at admission
+func validatePodAffinityTerm(term corev1.PodAffinityTerm, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	placeholder, err := renderTemplates(term, func(value string) (string, error) {
+		if _, err := parseEnvTemplate(term.TopologyKey, value); err != nil {
+			return "", err
+		}
+		return "placeholder", nil
+	})
+	if err != nil {
+		return append(allErrs, field.Invalid(fldPath.Child("labelSelector"), "", err.Error()))
+	}
+
+	if term.TopologyKey == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("topologyKey"), "can not be empty"))
+	} else {
+		allErrs = append(allErrs, metav1validation.ValidateLabelName(term.TopologyKey, fldPath.Child("topologyKey"))...)
+	}
+	if placeholder.LabelSelector != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(placeholder.LabelSelector,
+			metav1validation.LabelSelectorValidationOptions{}, fldPath.Child("labelSelector"))...)
+	}
+	if placeholder.NamespaceSelector != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(placeholder.NamespaceSelector,
+			metav1validation.LabelSelectorValidationOptions{}, fldPath.Child("namespaceSelector"))...)
+	}
+	return allErrs
+}
+
+func validateDNSList(list *DNSList, validateValue func(string, *field.Path) field.ErrorList, limit func([]string) string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if list == nil {
+		return allErrs
+	}
+
+	if len(list.Values) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath.Child("values"), ""))
+	}
+	for i, value := range list.Values {
+		allErrs = append(allErrs, validateValue(value, fldPath.Child("values").Index(i))...)
+	}
+	if reason := limit(list.Values); reason != "" {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("values"), list.Values, reason))
+	}
+	if list.Position != "" && !supportedDNSListPositions.Has(string(list.Position)) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("position"), list.Position, sets.List(supportedDNSListPositions)))
+	}
+	return allErrs
+}
+
+func validateIPAddress(ip string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if net.ParseIP(ip) == nil {
+		allErrs = append(allErrs, field.Invalid(fldPath, ip, "must be a valid IP address"))
+	}
+	return allErrs
+}
+
+func validateSearchDomain(search string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	// a trailing dot marks a fully qualified domain
+	for _, msg := range validation.IsDNS1123Subdomain(strings.TrimSuffix(search, ".")) {
+		allErrs = append(allErrs, field.Invalid(fldPath, search, msg))
+	}
+	return allErrs
+}
+
+func validateRemoveRules(rules *RemoveRules, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for i, rule := range rules.Env {
+		idxPath := fldPath.Child("env").Index(i)
+		if rule.NameRegex == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("nameRegex"), ""))
+		} else if re, err := regexp.Compile(rule.NameRegex); err != nil {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("nameRegex"), rule.NameRegex, err.Error()))
+		} else {
+			rules.Env[i].nameRegex = re
+		}
+		if containsContainerType(rule.ContainerTypes, ContainerTypeEphemeralContainers) {
+			allErrs = append(allErrs, field.Forbidden(idxPath.Child("containerTypes"), "ephemeral containers are added after admission of the pod"))
+		}
+		allErrs = append(allErrs, validateContainerTarget(rule.ContainerTarget, idxPath)...)
+	}
+	for i, rule := range rules.Tolerations {
+		idxPath := fldPath.Child("tolerations").Index(i)
+		if rule.Key == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("key"), ""))
+		}
+		if rule.Effect != "" && !supportedTaintEffects.Has(string(rule.Effect)) {
+			allErrs = append(allErrs, field.NotSupported(idxPath.Child("effect"), rule.Effect, sets.List(supportedTaintEffects)))
+		}
+	}
+	for i, key := range rules.TopologyConstraintKeys {
+		if key == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("topologyConstraintKeys").Index(i), ""))
+		}
+	}
+	for i, key := range rules.NodeAffinityKeys {
+		if key == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("nodeAffinityKeys").Index(i), ""))
+		}
+	}
+	for i, key := range rules.PodAntiAffinityTopologyKeys {
+		if key == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("podAntiAffinityTopologyKeys").Index(i), ""))
+		}
+	}
+	return allErrs
+}
+
+func validateVolume(volume corev1.Volume, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if volume.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("name"), ""))
+	} else {
+		for _, msg := range validation.IsDNS1123Label(volume.Name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("name"), volume.Name, msg))
+		}
+	}
+	if cmp.Equal(volume.VolumeSource, corev1.VolumeSource{}) {
+		allErrs = append(allErrs, field.Required(fldPath, "must specify a volume type"))
+	}
+	return allErrs
+}
+
+func validateVolumeMount(mount corev1.VolumeMount, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if mount.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("name"), ""))
+	}
+	if mount.MountPath == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("mountPath"), ""))
+	} else if strings.Contains(mount.MountPath, ":") {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("mountPath"), mount.MountPath, "must not contain ':'"))
+	}
+	if mount.SubPath != "" && mount.SubPathExpr != "" {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("subPathExpr"), mount.SubPathExpr, "subPathExpr and subPath are mutually exclusive"))
+	}
+	return allErrs
+}
+
+func validateObjectName(name string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if name == "" {
+		return append(allErrs, field.Required(fldPath, ""))
+	}
+	for _, msg := range validation.IsDNS1123Subdomain(name) {
+		allErrs = append(allErrs, field.Invalid(fldPath, name, msg))
+	}
+	return allErrs
+}
+
+func validateContainerTarget(target ContainerTarget, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for i, containerType := range target.ContainerTypes {
+		if !supportedContainerTypes.Has(string(containerType)) {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Child("containerTypes").Index(i), containerType, sets.List(supportedContainerTypes)))
+		}
+	}
+	allErrs = append(allErrs, validateContainerSelector(target.ContainerSelector, fldPath.Child("containerSelector"))...)
+	return allErrs
+}
+
+func validateMergeStrategy(strategy MergeStrategy, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if strategy != "" && !supportedMergeStrategies.Has(string(strategy)) {
+		allErrs = append(allErrs, field.NotSupported(fldPath, strategy, sets.List(supportedMergeStrategies)))
+	}
+	return allErrs
+}
+
+func validateContainerSelector(selector *ContainerSelector, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if selector == nil {
+		return allErrs
+	}
+
+	var errs field.ErrorList
+	selector.includeImages, errs = validateImagePatterns(selector.IncludeImages, fldPath.Child("includeImages"))
+	allErrs = append(allErrs, errs...)
+	selector.excludeImages, errs = validateImagePatterns(selector.ExcludeImages, fldPath.Child("excludeImages"))
+	allErrs = append(allErrs, errs...)
+	if selector.PortName != "" {
+		for _, msg := range validation.IsValidPortName(selector.PortName) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("portName"), selector.PortName, msg))
+		}
+	}
+	return allErrs
+}
+
+// validateImagePatterns compiles the image patterns. The compiled patterns are kept with the
+// config, so they are not compiled again for every admission request.
+func validateImagePatterns(patterns []string, fldPath *field.Path) ([]*regexp.Regexp, field.ErrorList) {
+	allErrs := field.ErrorList{}
+	var compiled []*regexp.Regexp
+	for i, pattern := range patterns {
+		re, err := compileImagePattern(pattern)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), pattern, err.Error()))
+			continue
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, allErrs
+}
+
+func validateNodeSelectorTerm(term corev1.NodeSelectorTerm, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath, "must have at least one of `matchExpressions` or `matchFields`"))
+	}
+	for j, req := range term.MatchExpressions {
+		allErrs = append(allErrs, validateNodeSelectorRequirement(req, fldPath.Child("matchExpressions").Index(j))...)
+	}
+	for j, req := range term.MatchFields {
+		reqPath := fldPath.Child("matchFields").Index(j)
+		if req.Key != metav1.ObjectNameField {
+			allErrs = append(allErrs, field.NotSupported(reqPath.Child("key"), req.Key, []string{metav1.ObjectNameField}))
+		}
+		if !supportedNodeFieldSelectorOperators.Has(string(req.Operator)) {
+			allErrs = append(allErrs, field.NotSupported(reqPath.Child("operator"), req.Operator, sets.List(supportedNodeFieldSelectorOperators)))
+		}
+		if len(req.Values) != 1 {
+			allErrs = append(allErrs, field.Required(reqPath.Child("values"), "must be only one value when `operator` is 'In' or 'NotIn' for node field selector"))
+		}
+	}
+	return allErrs
+}
+
+func validateNodeSelectorRequirement(req corev1.NodeSelectorRequirement, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	switch req.Operator {
+	case corev1.NodeSelectorOpIn, corev1.NodeSelectorOpNotIn:
+		if len(req.Values) == 0 {
+			allErrs = append(allErrs, field.Required(fldPath.Child("values"), "must be specified when `operator` is 'In' or 'NotIn'"))
+		}
+	case corev1.NodeSelectorOpExists, corev1.NodeSelectorOpDoesNotExist:
+		if len(req.Values) > 0 {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("values"), "may not be specified when `operator` is 'Exists' or 'DoesNotExist'"))
+		}
+	case corev1.NodeSelectorOpGt, corev1.NodeSelectorOpLt:
+		if len(req.Values) != 1 {
+			allErrs = append(allErrs, field.Required(fldPath.Child("values"), "must be specified single value when `operator` is 'Lt' or 'Gt'"))
+		} else if _, err := strconv.ParseInt(req.Values[0], 10, 64); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("values").Index(0), req.Values[0], "must be an integer"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("operator"), req.Operator, sets.List(supportedNodeSelectorOperators)))
+	}
+
+	allErrs = append(allErrs, metav1validation.ValidateLabelName(req.Key, fldPath.Child("key"))...)
+	return allErrs
+}
+
+func validateToleration(toleration corev1.Toleration, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	// validate the toleration key
+	if len(toleration.Key) > 0 {
+		allErrs = append(allErrs, metav1validation.ValidateLabelName(toleration.Key, fldPath.Child("key"))...)
+	}
+
+	// empty toleration key with Exists operator and empty value means match all taints
+	if len(toleration.Key) == 0 && toleration.Operator != corev1.TolerationOpExists {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("operator"), toleration.Operator,
+			"operator must be Exists when `key` is empty, which means \"match all values and all keys\""))
+	}
+
+	if toleration.TolerationSeconds != nil && toleration.Effect != corev1.TaintEffectNoExecute {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("effect"), toleration.Effect,
+			"effect must be 'NoExecute' when `tolerationSeconds` is set"))
+	}
+
+	// validate toleration operator and value
+	switch toleration.Operator {
+	// empty operator means Equal
+	case corev1.TolerationOpEqual, "":
+		for _, msg := range validation.IsValidLabelValue(toleration.Value) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("operator"), toleration.Value, msg))
+		}
+	case corev1.TolerationOpExists:
+		if len(toleration.Value) > 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("operator"), toleration,
+				"value must be empty when `operator` is 'Exists'"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("operator"), toleration.Operator, sets.List(supportedTolerationOperators)))
+	}
+
+	// validate toleration effect, empty toleration effect means match all taint effects
+	if len(toleration.Effect) > 0 && !supportedTaintEffects.Has(string(toleration.Effect)) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("effect"), toleration.Effect, sets.List(supportedTaintEffects)))
+	}
+	return allErrs
+}
+
+func validateTopologySpreadConstraint(constraint corev1.TopologySpreadConstraint, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if constraint.MaxSkew <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxSkew"), constraint.MaxSkew, "must be greater than zero"))
+	}
+	if constraint.TopologyKey == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("topologyKey"), "can not be empty"))
+	} else {
+		allErrs = append(allErrs, metav1validation.ValidateLabelName(constraint.TopologyKey, fldPath.Child("topologyKey"))...)
+	}
+	if !supportedScheduleActions.Has(string(constraint.WhenUnsatisfiable)) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("whenUnsatisfiable"), constraint.WhenUnsatisfiable, sets.List(supportedScheduleActions)))
+	}
+	if constraint.MinDomains != nil {
+		if *constraint.MinDomains <= 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("minDomains"), *constraint.MinDomains, "must be greater than 0"))
+		} else if constraint.WhenUnsatisfiable != corev1.DoNotSchedule {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("minDomains"), *constraint.MinDomains,
+				fmt.Sprintf("can only use minDomains if whenUnsatisfiable=%s", corev1.DoNotSchedule)))
+		}
+	}
+	if constraint.NodeAffinityPolicy != nil && !supportedPodTopologySpreadPolicy.Has(string(*constraint.NodeAffinityPolicy)) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("nodeAffinityPolicy"), *constraint.NodeAffinityPolicy, sets.List(supportedPodTopologySpreadPolicy)))
+	}
+	if constraint.NodeTaintsPolicy != nil && !supportedPodTopologySpreadPolicy.Has(string(*constraint.NodeTaintsPolicy)) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("nodeTaintsPolicy"), *constraint.NodeTaintsPolicy, sets.List(supportedPodTopologySpreadPolicy)))
+	}
+	if constraint.LabelSelector != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(constraint.LabelSelector,
+			metav1validation.LabelSelectorValidationOptions{}, fldPath.Child("labelSelector"))...)
+	}
+	for i, key := range constraint.MatchLabelKeys {
+		allErrs = append(allErrs, metav1validation.ValidateLabelName(key, fldPath.Child("matchLabelKeys").Index(i))...)
+	}
+	return allErrs
+}
+
+// configErrorLines renders errors as "file:line: message". Lines are looked up by walking the
+// YAML document along the field path of each error; 0 is used when no line can be found.
+func configErrorLines(configFile string, data []byte, errs []error) []string {
+	var root yamlv3.Node
+	_ = yamlv3.Unmarshal(data, &root)
+
+	lines := make([]string, 0, len(errs))
+	for _, err := range errs {
+		line := 0
+		if fieldErr, ok := err.(*field.Error); ok {
+			line = lineForPath(&root, fieldErr.Field)
+		} else if match := strictFieldPattern.FindStringSubmatch(err.Error()); match != nil {
+			line = lineForPath(&root, match[1])
+		}
+		lines = append(lines, fmt.Sprintf("%s:%d: %v", configFile, line, err))
+	}
+	return lines
+}
+
+// lineForPath returns the line of the deepest node reachable along a field path such as
+// "profiles[0].tolerations[1].operator"
+func lineForPath(node *yamlv3.Node, path string) int {
+	if node.Kind == yamlv3.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := node.Line
+
+	for _, segment := range splitFieldPath(path) {
+		var next *yamlv3.Node
+		switch node.Kind {
+		case yamlv3.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == segment {
+					next = node.Content[i+1]
+					line = node.Content[i].Line
+					break
+				}
+			}
+		case yamlv3.SequenceNode:
+			if idx, err := strconv.Atoi(segment); err == nil && idx < len(node.Content) {
+				next = node.Content[idx]
+				line = next.Line
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return line
+}
+
+// splitFieldPath splits "a.b[0].c[key.with.dots]" into ["a", "b", "0", "c", "key.with.dots"]
+func splitFieldPath(path string) []string {
+	var segments []string
+	for len(path) > 0 {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return append(segments, path[1:])
+			}
+			segments = append(segments, path[1:end])
+			path = path[end+1:]
+		default:
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			segments = append(segments, path[:end])
+			path = path[end:]
+		}
+	}
+	return segments
+}
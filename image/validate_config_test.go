@@ -0,0 +1,630 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// checkErrors fails the test unless every error matches the substring at the same position
+func checkErrors(t *testing.T, errs []error, want []string) {
+	t.Helper()
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors, want %d: %v", len(errs), len(want), errs)
+	}
+	for i, err := range errs {
+		if !strings.Contains(err.Error(), want[i]) {
+			t.Errorf("error %d = %q, want it to contain %q", i, err, want[i])
+		}
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		wantErrs []string
+	}{
+		{
+			name: "legacy single profile",
+			config: `
+env:
+  - name: REGION
+    value: westeurope
+`,
+		},
+		{
+			name: "profiles",
+			config: `
+profiles:
+  - name: web
+    podSelector:
+      matchLabels:
+        app-type: web
+    env:
+      - name: REGION
+        value: westeurope
+  - name: spot
+    tolerations:
+      - key: kubernetes.azure.com/scalesetpriority
+        operator: Equal
+        value: spot
+        effect: NoSchedule
+`,
+		},
+		{
+			name: "unknown field",
+			config: `
+profiles:
+  - name: web
+    toleration:
+      - key: spot
+`,
+			wantErrs: []string{`unknown field "profiles[0].toleration"`},
+		},
+		{
+			name: "keys differing in case",
+			config: `
+profiles:
+  - name: web
+    Tolerations:
+      - key: spot
+        operator: Exists
+    env:
+      - name: REGION
+        Value: westeurope
+`,
+			wantErrs: []string{`unknown field "profiles[0].Tolerations"`, `unknown field "profiles[0].env[0].Value"`},
+		},
+		{
+			name: "duplicate key",
+			config: `
+labels:
+  team: web
+labels:
+  team: shop
+`,
+			wantErrs: []string{`key "labels" already set in map`},
+		},
+		{
+			name: "unknown legacy field",
+			config: `
+envs:
+  - name: REGION
+`,
+			wantErrs: []string{`unknown field "envs"`},
+		},
+		{
+			name: "profile names",
+			config: `
+profiles:
+  - name: web
+  - name: web
+  - env: []
+`,
+			wantErrs: []string{
+				`profiles[1].name: Duplicate value: "web"`,
+				`profiles[2].name: Required value`,
+			},
+		},
+		{
+			name: "env names",
+			config: `
+profiles:
+  - name: web
+    env:
+      - value: missing-name
+      - name: "1INVALID="
+        value: x
+`,
+			wantErrs: []string{
+				`profiles[0].env[0].name: Required value`,
+				`profiles[0].env[1].name: Invalid value: "1INVALID="`,
+			},
+		},
+		{
+			name: "env valueFrom",
+			config: `
+profiles:
+  - name: web
+    env:
+      - name: BOTH
+        value: x
+        valueFrom:
+          fieldRef:
+            fieldPath: metadata.name
+      - name: NONE
+        valueFrom: {}
+`,
+			wantErrs: []string{
+				"profiles[0].env[0].valueFrom: Invalid value: \"\": may not be specified when `value` is not empty",
+				"profiles[0].env[1].valueFrom: Invalid value: \"\": must specify exactly one of",
+			},
+		},
+		{
+			name: "tolerations",
+			config: `
+profiles:
+  - name: spot
+    tolerations:
+      - key: spot
+        operator: Equals
+        value: "true"
+      - key: spot
+        operator: Exists
+        value: "true"
+      - key: spot
+        effect: NoExecution
+`,
+			wantErrs: []string{
+				`profiles[0].tolerations[0].operator: Unsupported value: "Equals"`,
+				"value must be empty when `operator` is 'Exists'",
+				`profiles[0].tolerations[2].effect: Unsupported value: "NoExecution"`,
+			},
+		},
+		{
+			name: "topology spread constraints",
+			config: `
+profiles:
+  - name: zones
+    topologyConstraints:
+      - maxSkew: 0
+        topologyKey: topology.kubernetes.io/zone
+        whenUnsatisfiable: DoNotSchedule
+      - maxSkew: 1
+        whenUnsatisfiable: Sometimes
+`,
+			wantErrs: []string{
+				`profiles[0].topologyConstraints[0].maxSkew: Invalid value: 0`,
+				`profiles[0].topologyConstraints[1].topologyKey: Required value`,
+				`profiles[0].topologyConstraints[1].whenUnsatisfiable: Unsupported value: "Sometimes"`,
+			},
+		},
+		{
+			name: "node affinity terms",
+			config: `
+profiles:
+  - name: system
+    requiredNodeAffinityTerms:
+      - matchExpressions:
+          - key: kubernetes.azure.com/mode
+            operator: In
+      - matchExpressions:
+          - key: kubernetes.azure.com/mode
+            operator: Equals
+            values: [system]
+    preferredNodeAffinityTerms:
+      - weight: 101
+        preference:
+          matchExpressions:
+            - key: kubernetes.azure.com/mode
+              operator: DoesNotExist
+`,
+			wantErrs: []string{
+				`profiles[0].requiredNodeAffinityTerms[0].matchExpressions[0].values: Required value`,
+				`profiles[0].requiredNodeAffinityTerms[1].matchExpressions[0].operator: Unsupported value: "Equals"`,
+				`profiles[0].preferredNodeAffinityTerms[0].weight: Invalid value: 101`,
+			},
+		},
+		{
+			name: "env templates",
+			config: `
+env:
+  - name: BROKEN
+    value: "{{ .Labels.app "
+  - name: STATIC
+    value: plain
+    default: fallback
+`,
+			wantErrs: []string{
+				`env[0].value: Invalid value: "{{ .Labels.app "`,
+				"env[1].default: Forbidden: may only be specified when `value` is a template",
+			},
+		},
+		{
+			name: "container types",
+			config: `
+env:
+  - name: REGION
+    value: westeurope
+    containerTypes: [initContainers, sidecars]
+`,
+			wantErrs: []string{`env[0].containerTypes[1]: Unsupported value: "sidecars"`},
+		},
+		{
+			name: "container selector",
+			config: `
+containerSelector:
+  includeImages: ["regex:("]
+  portName: "http_port"
+env:
+  - name: REGION
+    value: westeurope
+`,
+			wantErrs: []string{
+				`containerSelector.includeImages[0]: Invalid value: "regex:("`,
+				`containerSelector.portName: Invalid value: "http_port"`,
+			},
+		},
+		{
+			name: "merge strategies",
+			config: `
+env:
+  - name: REGION
+    value: westeurope
+    mergeStrategy: replace
+mergeStrategies:
+  tolerations: ignore
+`,
+			wantErrs: []string{
+				`env[0].mergeStrategy: Unsupported value: "replace"`,
+				`mergeStrategies.tolerations: Unsupported value: "ignore"`,
+			},
+		},
+		{
+			name: "envFrom",
+			config: `
+envFrom:
+  - prefix: APP_
+  - configMapRef:
+      name: app-config
+    secretRef:
+      name: db-credentials
+  - secretRef:
+      name: Invalid_Name
+`,
+			wantErrs: []string{
+				"envFrom[0]: Invalid value: \"\": must specify one of: `configMapRef` or `secretRef`",
+				`envFrom[1]: Invalid value: "": may not have more than one field specified at a time`,
+				`envFrom[2].secretRef.name: Invalid value: "Invalid_Name"`,
+			},
+		},
+		{
+			name: "volume mount paths",
+			config: `
+volumes:
+  - name: custom-ca
+    configMap:
+      name: custom-ca
+  - name: custom-ca
+    secret:
+      secretName: custom-ca
+  - name: empty
+volumeMounts:
+  - name: custom-ca
+    mountPath: /etc/ssl/custom
+  - name: empty
+    mountPath: /etc/ssl/custom
+    containerSelector:
+      includeNames: [worker]
+  - name: empty
+    mountPath: "C:/data"
+`,
+			wantErrs: []string{
+				`volumes[1].name: Duplicate value: "custom-ca"`,
+				`volumes[2]: Required value: must specify a volume type`,
+				`volumeMounts[1].mountPath: Duplicate value: "/etc/ssl/custom"`,
+				`volumeMounts[2].mountPath: Invalid value: "C:/data": must not contain ':'`,
+			},
+		},
+		{
+			name: "sidecars",
+			config: `
+sidecars:
+  - name: log-shipper
+    image: fluent/fluent-bit:3.1
+  - name: log-shipper
+    image: fluent/fluent-bit:3.1
+  - name: Proxy
+  - name: agent
+    image: agent
+    restartPolicy: Always
+  - name: broken
+    image: "{{ .Labels.app"
+`,
+			wantErrs: []string{
+				`sidecars[1].name: Duplicate value: "log-shipper"`,
+				`sidecars[2].name: Invalid value: "Proxy"`,
+				`sidecars[2].image: Required value`,
+				`sidecars[3].restartPolicy: Forbidden: use ` + "`nativeSidecar`",
+				`sidecars[4]: Invalid value: "broken"`,
+			},
+		},
+		{
+			name: "resources",
+			config: `
+resources:
+  requests:
+    cpu: "2"
+    nvidia.com/gpu: "1"
+  limits:
+    cpu: "1"
+    memory: -1Gi
+  min:
+    memory: 2Gi
+  max:
+    memory: 1Gi
+  limitRequestRatio:
+    cpu: 0.5
+  containerTypes: [ephemeralContainers]
+`,
+			wantErrs: []string{
+				`resources.requests[nvidia.com/gpu]: Unsupported value: nvidia.com/gpu`,
+				`resources.limits[memory]: Invalid value: "-1Gi": must be greater than or equal to 0`,
+				`resources.limitRequestRatio[cpu]: Invalid value: 0.5: must be greater than or equal to 1`,
+				`resources.requests[cpu]: Invalid value: "2": must be less than or equal to limits`,
+				`resources.min[memory]: Invalid value: "2Gi": must be less than or equal to max`,
+				`resources.containerTypes: Forbidden: ephemeral containers may not have resources`,
+			},
+		},
+		{
+			name: "labels and annotations",
+			config: `
+labels:
+  example.com/team: platform
+  -invalid: x
+  tier: "not a valid value"
+annotations:
+  example.com/note: any value / is fine
+  env-injector-webhook-status: injected
+`,
+			wantErrs: []string{
+				`labels: Invalid value: "-invalid"`,
+				`labels[tier]: Invalid value: "not a valid value"`,
+				`annotations[env-injector-webhook-status]: Forbidden: is managed by the webhook`,
+			},
+		},
+		{
+			name: "node selector",
+			config: `
+nodeSelector:
+  node.example.com/pool: spot
+  node.example.com/zone: "west europe"
+requiredNodeAffinityTerms:
+  - matchExpressions:
+      - key: node.example.com/pool
+        operator: NotIn
+        values: [spot]
+`,
+			wantErrs: []string{
+				`nodeSelector[node.example.com/zone]: Invalid value: "west europe"`,
+				`nodeSelector: Invalid value: "": nodeSelector node.example.com/pool=spot contradicts required node affinity node.example.com/pool NotIn [spot]`,
+			},
+		},
+		{
+			name: "scheduling fields",
+			config: `
+priorityClassName:
+  value: Spot_Low
+schedulerName:
+  force: true
+preemptionPolicy:
+  value: Sometimes
+schedulingGates:
+  names: [example.com/quota-check, example.com/quota-check]
+`,
+			wantErrs: []string{
+				`priorityClassName.value: Invalid value: "Spot_Low"`,
+				`schedulerName.value: Required value`,
+				`preemptionPolicy.value: Unsupported value: "Sometimes"`,
+				`schedulingGates.names[1]: Duplicate value: "example.com/quota-check"`,
+			},
+		},
+		{
+			name: "security context",
+			config: `
+securityContext:
+  seccompProfile:
+    type: Unconfined
+    localhostProfile: profiles/audit.json
+  dropCapabilities: [""]
+  containerTypes: [ephemeralContainers]
+  mergeStrategies:
+    runAsNonRoot: replace
+`,
+			wantErrs: []string{
+				`securityContext.seccompProfile.type: Unsupported value: "Unconfined"`,
+				`securityContext.seccompProfile.localhostProfile: Invalid value: "profiles/audit.json": can only be set when seccomp type is Localhost`,
+				`securityContext.dropCapabilities[0]: Required value`,
+				`securityContext.containerTypes: Forbidden: ephemeral containers are added after admission of the pod`,
+				`securityContext.mergeStrategies.runAsNonRoot: Unsupported value: "replace"`,
+			},
+		},
+		{
+			name: "image rewrite",
+			config: `
+imageRewrite:
+  rules:
+    - replacement: mirror.local/
+    - prefix: docker.io/
+      regex: ^docker\.io/
+      replacement: mirror.local/
+    - regex: "("
+      replacement: mirror.local/
+    - prefix: quay.io/
+  exclude: ["regex:["]
+`,
+			wantErrs: []string{
+				`imageRewrite.rules[0]: Required value: must specify prefix or regex`,
+				`imageRewrite.rules[1]: Invalid value: "": prefix and regex are mutually exclusive`,
+				`imageRewrite.rules[2].regex: Invalid value: "("`,
+				`imageRewrite.rules[3].replacement: Required value`,
+				`imageRewrite.exclude[0]: Invalid value: "regex:["`,
+			},
+		},
+		{
+			name: "image pull secrets",
+			config: `
+imagePullSecrets:
+  - name: mirror-credentials
+  - name: mirror-credentials
+  - name: Mirror_Credentials
+  - requireExists: true
+`,
+			wantErrs: []string{
+				`imagePullSecrets[1].name: Duplicate value: "mirror-credentials"`,
+				`imagePullSecrets[2].name: Invalid value: "Mirror_Credentials"`,
+				`imagePullSecrets[3].name: Required value`,
+			},
+		},
+		{
+			name: "remove rules",
+			config: `
+remove:
+  env:
+    - nameRegex: "("
+    - containerTypes: [ephemeralContainers]
+  tolerations:
+    - effect: NoSchedule
+    - key: spot
+      effect: Sometimes
+  topologyConstraintKeys: [""]
+  nodeAffinityKeys: [""]
+  podAntiAffinityTopologyKeys: [""]
+`,
+			wantErrs: []string{
+				`remove.env[0].nameRegex: Invalid value: "("`,
+				`remove.env[1].nameRegex: Required value`,
+				`remove.env[1].containerTypes: Forbidden: ephemeral containers are added after admission of the pod`,
+				`remove.tolerations[0].key: Required value`,
+				`remove.tolerations[1].effect: Unsupported value: "Sometimes"`,
+				`remove.topologyConstraintKeys[0]: Required value`,
+				`remove.nodeAffinityKeys[0]: Required value`,
+				`remove.podAntiAffinityTopologyKeys[0]: Required value`,
+			},
+		},
+		{
+			name: "duplicate topology spread constraints",
+			config: `
+topologyConstraints:
+  - maxSkew: 1
+    topologyKey: topology.kubernetes.io/zone
+    whenUnsatisfiable: DoNotSchedule
+  - maxSkew: 2
+    topologyKey: topology.kubernetes.io/zone
+    whenUnsatisfiable: ScheduleAnyway
+  - maxSkew: 3
+    topologyKey: topology.kubernetes.io/zone
+    whenUnsatisfiable: DoNotSchedule
+`,
+			wantErrs: []string{
+				`topologyConstraints[2]: Duplicate value: "{topology.kubernetes.io/zone, DoNotSchedule}"`,
+			},
+		},
+		{
+			name: "pod affinity terms",
+			config: `
+podAffinityTerms:
+  required:
+    - labelSelector:
+        matchLabels:
+          app: cache
+podAntiAffinityTerms:
+  preferred:
+    - weight: 0
+      podAffinityTerm:
+        topologyKey: kubernetes.io/hostname
+        labelSelector:
+          matchLabels:
+            app: "{{ .Labels.app"
+`,
+			wantErrs: []string{
+				`podAffinityTerms.required[0].topologyKey: Required value`,
+				`podAntiAffinityTerms.preferred[0].weight: Invalid value: 0`,
+				`podAntiAffinityTerms.preferred[0].podAffinityTerm.labelSelector: Invalid value`,
+			},
+		},
+		{
+			name: "dns config",
+			config: `
+dnsNameservers:
+  values: [10.0.0.1, 10.0.0.2, 10.0.0.3, 10.0.0.4]
+  position: middle
+dnsSearches:
+  values: []
+dnsPolicy:
+  value: None
+`,
+			wantErrs: []string{
+				`dnsNameservers.values: Invalid value`,
+				`dnsNameservers.position: Unsupported value: "middle"`,
+				`dnsSearches.values: Required value`,
+			},
+		},
+		{
+			name: "dnsPolicy None without nameservers",
+			config: `
+dnsPolicy:
+  value: None
+`,
+			wantErrs: []string{
+				`dnsNameservers: Required value: must provide at least one DNS nameserver when dnsPolicy is None`,
+			},
+		},
+		{
+			name: "host aliases",
+			config: `
+hostAliases:
+  - ip: 10.0.0.5
+    hostnames: [legacy-db]
+  - ip: 10.0.0.5
+    hostnames: [Legacy_DB]
+  - ip: not-an-ip
+    hostnames: [cache]
+`,
+			wantErrs: []string{
+				`hostAliases[1].ip: Duplicate value: "10.0.0.5"`,
+				`hostAliases[1].hostnames[0]: Invalid value: "Legacy_DB"`,
+				`hostAliases[2].ip: Invalid value: "not-an-ip"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := parseConfig([]byte(tt.config))
+			checkErrors(t, errs, tt.wantErrs)
+		})
+	}
+}
+
+func TestConfigErrorLines(t *testing.T) {
+	config := `profiles:
+  - name: spot
+    tolerations:
+      - key: spot
+        operator: Equals
+  - name: web
+    envs: []
+  - name: batch
+    tolerations:
+      - key: batch
+        operater: Exists
+`
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{name: "field error", config: strings.Replace(strings.Replace(config, "envs", "env", 1), "operater", "operator", 1), want: []string{
+			"envconfig.yaml:5: profiles[0].tolerations[0].operator",
+		}},
+		{name: "unknown fields", config: config, want: []string{
+			`envconfig.yaml:7: unknown field "profiles[1].envs"`,
+			`envconfig.yaml:11: unknown field "profiles[2].tolerations[0].operater"`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := parseConfig([]byte(tt.config))
+			lines := configErrorLines("envconfig.yaml", []byte(tt.config), errs)
+			if len(lines) != len(tt.want) {
+				t.Fatalf("got %q, want %d lines", lines, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(lines[i], want) {
+					t.Errorf("line %d = %q, want it to start with %q", i, lines[i], want)
+				}
+			}
+		})
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"sync/atomic"
 
 	v1 "k8s.io/api/admission/v1"
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
@@ -28,10 +30,18 @@ var ignoredNamespaces = []string{
 const (
 	admissionWebhookAnnotationInjectKey = "env-injector-webhook-inject"
 	admissionWebhookAnnotationStatusKey = "env-injector-webhook-status"
+	// admissionWebhookAnnotationSidecarKey opts a pod out of sidecar injection only
+	admissionWebhookAnnotationSidecarKey = "env-injector-webhook-inject-sidecars"
+
+	injectedStatus     = "injected"
+	defaultProfileName = "default"
 )
 
 type WebhookServer struct {
-	envConfig *Config
+	envConfig atomic.Pointer[EnvConfig] // swapped by watchConfig on reload
+	policies  *policyStore              // nil unless EnvPatchPolicy support is enabled
+	objects   *objectCache              // nil unless reference checks are enabled
+	workloads bool                      // whether the pod templates of workloads are mutated
 	server    *http.Server
 }
 
@@ -41,17 +51,64 @@ type WhSvrParameters struct {
 	certFile   string // path to the x509 certificate for https
 	keyFile    string // path to the x509 private key matching `CertFile`
 	envCfgFile string // path to env injector configuration file
+	kubeconfig string // path to a kubeconfig, in-cluster config is used when empty
+	policies   bool   // whether to load EnvPatchPolicy custom resources
+	references bool   // whether to check that referenced ConfigMaps and Secrets exist
+	workloads  bool   // whether to mutate the pod templates of workload resources
+}
+
+// EnvConfig is the layout of the mutation configuration file. Profiles are
+// evaluated in the order they are declared and every matching profile is applied.
+type EnvConfig struct {
+	Profiles []Profile `yaml:"profiles" json:"profiles"`
+
+	checksum [sha256.Size]byte // checksum of the file the config was loaded from
+	legacy   bool              // whether the file holds a single profile at the top level
+}
+
+// Profile is a named set of mutations applied to pods matching its podSelector
+type Profile struct {
+	Name   string `yaml:"name" json:"name"`
+	Config `yaml:",inline"`
+
+	policy string // name of the EnvPatchPolicy resource the profile comes from, if any
 }
 
 type Config struct {
-	Env                        []corev1.EnvVar                   `yaml:"env"`
-	DnsOptions                 []corev1.PodDNSConfigOption       `yaml:"dnsOptions,omitempty"`
-	RequiredNodeAffinityTerms  []corev1.NodeSelectorTerm         `yaml:"requiredNodeAffinityTerms,omitempty"`
-	PreferredNodeAffinityTerms []corev1.PreferredSchedulingTerm  `yaml:"preferredNodeAffinityTerms,omitempty"`
-	Tolerations                []corev1.Toleration               `yaml:"tolerations,omitempty"`
-	TopologyConstraints        []corev1.TopologySpreadConstraint `yaml:"topologyConstraints,omitempty"`
-	RemovePodAntiAffinity      bool                              `yaml:"removePodAntiAffinity,omitempty"`
-	PodSelector                *metav1.LabelSelector             `yaml:"podSelector,omitempty"`
+	Env                        []EnvVar                         `yaml:"env" json:"env"`
+	EnvFrom                    []EnvFromSource                  `yaml:"envFrom,omitempty" json:"envFrom,omitempty"`
+	Volumes                    []Volume                         `yaml:"volumes,omitempty" json:"volumes,omitempty"`
+	VolumeMounts               []VolumeMount                    `yaml:"volumeMounts,omitempty" json:"volumeMounts,omitempty"`
+	Sidecars                   []Sidecar                        `yaml:"sidecars,omitempty" json:"sidecars,omitempty"`
+	Resources                  *ResourceDefaults                `yaml:"resources,omitempty" json:"resources,omitempty"`
+	Labels                     map[string]string                `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Annotations                map[string]string                `yaml:"annotations,omitempty" json:"annotations,omitempty"`
+	NodeSelector               map[string]string                `yaml:"nodeSelector,omitempty" json:"nodeSelector,omitempty"`
+	PriorityClassName          *SchedulingField                 `yaml:"priorityClassName,omitempty" json:"priorityClassName,omitempty"`
+	SchedulerName              *SchedulingField                 `yaml:"schedulerName,omitempty" json:"schedulerName,omitempty"`
+	RuntimeClassName           *SchedulingField                 `yaml:"runtimeClassName,omitempty" json:"runtimeClassName,omitempty"`
+	PreemptionPolicy           *SchedulingField                 `yaml:"preemptionPolicy,omitempty" json:"preemptionPolicy,omitempty"`
+	SchedulingGates            *SchedulingGates                 `yaml:"schedulingGates,omitempty" json:"schedulingGates,omitempty"`
+	SecurityContext            *SecurityContextDefaults         `yaml:"securityContext,omitempty" json:"securityContext,omitempty"`
+	ImageRewrite               *ImageRewrite                    `yaml:"imageRewrite,omitempty" json:"imageRewrite,omitempty"`
+	ImagePullSecrets           []ImagePullSecret                `yaml:"imagePullSecrets,omitempty" json:"imagePullSecrets,omitempty"`
+	DnsOptions                 []DNSOption                      `yaml:"dnsOptions,omitempty" json:"dnsOptions,omitempty"`
+	DnsNameservers             *DNSList                         `yaml:"dnsNameservers,omitempty" json:"dnsNameservers,omitempty"`
+	DnsSearches                *DNSList                         `yaml:"dnsSearches,omitempty" json:"dnsSearches,omitempty"`
+	DnsPolicy                  *SchedulingField                 `yaml:"dnsPolicy,omitempty" json:"dnsPolicy,omitempty"`
+	HostAliases                []HostAlias                      `yaml:"hostAliases,omitempty" json:"hostAliases,omitempty"`
+	RequiredNodeAffinityTerms  []corev1.NodeSelectorTerm        `yaml:"requiredNodeAffinityTerms,omitempty" json:"requiredNodeAffinityTerms,omitempty"`
+	PreferredNodeAffinityTerms []corev1.PreferredSchedulingTerm `yaml:"preferredNodeAffinityTerms,omitempty" json:"preferredNodeAffinityTerms,omitempty"`
+	Tolerations                []Toleration                     `yaml:"tolerations,omitempty" json:"tolerations,omitempty"`
+	TopologyConstraints        []TopologySpreadConstraint       `yaml:"topologyConstraints,omitempty" json:"topologyConstraints,omitempty"`
+	RemovePodAntiAffinity      bool                             `yaml:"removePodAntiAffinity,omitempty" json:"removePodAntiAffinity,omitempty"`
+	PodAntiAffinity            *PodAntiAffinityRewrite          `yaml:"podAntiAffinity,omitempty" json:"podAntiAffinity,omitempty"`
+	PodAffinityTerms           *PodAffinityTerms                `yaml:"podAffinityTerms,omitempty" json:"podAffinityTerms,omitempty"`
+	PodAntiAffinityTerms       *PodAffinityTerms                `yaml:"podAntiAffinityTerms,omitempty" json:"podAntiAffinityTerms,omitempty"`
+	Remove                     *RemoveRules                     `yaml:"remove,omitempty" json:"remove,omitempty"`
+	PodSelector                *metav1.LabelSelector            `yaml:"podSelector,omitempty" json:"podSelector,omitempty"`
+	ContainerSelector          *ContainerSelector               `yaml:"containerSelector,omitempty" json:"containerSelector,omitempty"`
+	MergeStrategies            MergeStrategies                  `yaml:"mergeStrategies,omitempty" json:"mergeStrategies,omitempty"`
 }
 
 type patchOperation struct {
@@ -65,9 +122,57 @@ func init() {
 	_ = admissionregistrationv1.AddToScheme(runtimeScheme)
 }
 
-// main mutation process
+// activeConfig returns the profiles from the configuration file followed by the
+// EnvPatchPolicy profiles, when enabled
+func (whsvr *WebhookServer) activeConfig() *EnvConfig {
+	envConfig := whsvr.envConfig.Load()
+	if whsvr.policies == nil {
+		return envConfig
+	}
+
+	merged := &EnvConfig{checksum: envConfig.checksum}
+	merged.Profiles = append(merged.Profiles, envConfig.Profiles...)
+	merged.Profiles = append(merged.Profiles, whsvr.policies.Profiles()...)
+	return merged
+}
+
+// podGVK is the kind of pods, and of their ephemeralcontainers subresource
+var podGVK = metav1.GroupVersionKind{Version: "v1", Kind: "Pod"}
+
+// main mutation process. Requests are dispatched by kind and operation, so that a webhook rule
+// matching other resources or operations cannot produce patches meant for pod creation.
 func (whsvr *WebhookServer) mutate(ar *v1.AdmissionReview) *v1.AdmissionResponse {
 	req := ar.Request
+	structuredLog(LogLevelInfo, "Webhook", "收到准入审查请求 Kind=%v, Namespace=%v Name=%v SubResource=%v UID=%v Operation=%v UserInfo=%v",
+		req.Kind, req.Namespace, req.Name, req.SubResource, req.UID, req.Operation, req.UserInfo)
+
+	if req.Kind == podGVK {
+		return whsvr.mutatePodRequest(req)
+	}
+	if templatePath, ok := workloadTemplatePaths[req.Kind]; ok {
+		switch {
+		case req.SubResource != "" || (req.Operation != v1.Create && req.Operation != v1.Update):
+			structuredLog(LogLevelInfo, "Webhook", "不处理 %s %s/%s 的 %s 操作（子资源: %q），跳过变更", req.Kind.Kind, req.Namespace, req.Name, req.Operation, req.SubResource)
+		case req.Operation == v1.Update && immutableTemplateKinds[req.Kind]:
+			structuredLog(LogLevelInfo, "Webhook", "%s %s/%s 的 Pod 模板创建后不可修改，更新时跳过变更", req.Kind.Kind, req.Namespace, req.Name)
+		case !whsvr.workloads:
+			structuredLog(LogLevelInfo, "Webhook", "未启用工作负载模板变更，跳过 %s %s/%s", req.Kind.Kind, req.Namespace, req.Name)
+		default:
+			return whsvr.mutateWorkload(req, templatePath)
+		}
+		return &v1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+
+	structuredLog(LogLevelWarning, "Webhook", "不支持的资源类型 %v（%s/%s），未做变更，请检查 webhook 规则", req.Kind, req.Namespace, req.Name)
+	return &v1.AdmissionResponse{
+		Allowed: true,
+	}
+}
+
+// mutatePodRequest dispatches a pod request by subresource and operation
+func (whsvr *WebhookServer) mutatePodRequest(req *v1.AdmissionRequest) *v1.AdmissionResponse {
 	var pod corev1.Pod
 	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
 		structuredLog(LogLevelError, "Webhook", "无法解析原始对象: %v", err)
@@ -78,19 +183,48 @@ func (whsvr *WebhookServer) mutate(ar *v1.AdmissionReview) *v1.AdmissionResponse
 		}
 	}
 
-	structuredLog(LogLevelInfo, "Webhook", "收到准入审查请求 Kind=%v, Namespace=%v Name=%v (%v) UID=%v Operation=%v UserInfo=%v",
-		req.Kind, req.Namespace, req.Name, pod.Name, req.UID, req.Operation, req.UserInfo)
+	// pods created by controllers do not carry their namespace in the object yet
+	if pod.Namespace == "" {
+		pod.Namespace = req.Namespace
+	}
+
+	switch {
+	case req.SubResource == "ephemeralcontainers" && req.Operation == v1.Update:
+		return whsvr.mutateEphemeralContainers(req, &pod)
+	case req.SubResource == "" && req.Operation == v1.Create:
+		return whsvr.mutatePod(req, &pod)
+	case req.SubResource == "" && req.Operation == v1.Update:
+		return whsvr.mutatePodUpdate(req, &pod)
+	}
 
+	structuredLog(LogLevelInfo, "Webhook", "不处理 Pod %s/%s 的 %s 操作（子资源: %q），跳过变更", pod.Namespace, pod.Name, req.Operation, req.SubResource)
+	return &v1.AdmissionResponse{
+		Allowed: true,
+	}
+}
+
+// mutatePod injects the matching profiles into a pod being created
+func (whsvr *WebhookServer) mutatePod(req *v1.AdmissionRequest, pod *corev1.Pod) *v1.AdmissionResponse {
 	// determine whether to perform mutation
-	if !mutationRequired(ignoredNamespaces, &pod.ObjectMeta, whsvr.envConfig) {
+	if !mutationRequired(ignoredNamespaces, &pod.ObjectMeta) {
 		structuredLog(LogLevelInfo, "Webhook", "根据策略检查跳过对 %s/%s 的变更", pod.Namespace, pod.Name)
 		return &v1.AdmissionResponse{
 			Allowed: true,
 		}
 	}
 
-	annotations := map[string]string{admissionWebhookAnnotationStatusKey: "injected"}
-	patchBytes, err := createPatch(&pod, whsvr.envConfig, annotations)
+	// load the active config once so a concurrent reload cannot change it mid-request
+	profiles := matchingProfiles(whsvr.activeConfig(), &pod.ObjectMeta)
+	if len(profiles) == 0 {
+		structuredLog(LogLevelInfo, "Webhook", "没有匹配 %s/%s 的配置档案，跳过变更", pod.Namespace, pod.Name)
+		return &v1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+
+	mc := &mutationContext{objects: whsvr.objects}
+	annotations := map[string]string{admissionWebhookAnnotationStatusKey: injectionStatus(profiles)}
+	patchBytes, err := createPatch(mc, pod, profiles, annotations)
 	if err != nil {
 		return &v1.AdmissionResponse{
 			Result: &metav1.Status{
@@ -99,7 +233,44 @@ func (whsvr *WebhookServer) mutate(ar *v1.AdmissionReview) *v1.AdmissionResponse
 		}
 	}
 
+	if whsvr.policies != nil && (req.DryRun == nil || !*req.DryRun) {
+		whsvr.policies.recordMutation(profiles)
+	}
+
 	structuredLog(LogLevelDebug, "Webhook", "准入响应补丁内容: %s", string(patchBytes))
+	return &v1.AdmissionResponse{
+		Allowed:  true,
+		Warnings: mc.warnings,
+		Patch:    patchBytes,
+		PatchType: func() *v1.PatchType {
+			pt := v1.PatchTypeJSONPatch
+			return &pt
+		}(),
+	}
+}
+
+// mutatePodUpdate handles updates of pods that were not injected on creation, e.g. because they
+// predate the webhook. Only the fields that may change after creation are patched, and the
+// status annotation is not set as the pod is not fully injected.
+func (whsvr *WebhookServer) mutatePodUpdate(req *v1.AdmissionRequest, pod *corev1.Pod) *v1.AdmissionResponse {
+	if !mutationRequired(ignoredNamespaces, &pod.ObjectMeta) {
+		structuredLog(LogLevelInfo, "Webhook", "根据策略检查跳过对 %s/%s 的更新变更", pod.Namespace, pod.Name)
+		return &v1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+
+	profiles := matchingProfiles(whsvr.activeConfig(), &pod.ObjectMeta)
+	patchBytes, err := createUpdatePatch(pod, profiles)
+	if err != nil {
+		return &v1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: err.Error(),
+			},
+		}
+	}
+
+	structuredLog(LogLevelDebug, "Webhook", "Pod 更新准入响应补丁内容: %s", string(patchBytes))
 	return &v1.AdmissionResponse{
 		Allowed: true,
 		Patch:   patchBytes,
@@ -110,6 +281,49 @@ func (whsvr *WebhookServer) mutate(ar *v1.AdmissionReview) *v1.AdmissionResponse
 	}
 }
 
+// mutateEphemeralContainers handles updates of the pods/ephemeralcontainers subresource so that
+// debug containers inherit the injected environment. The pod itself was already injected on
+// creation, so only the namespace and opt-out checks apply.
+func (whsvr *WebhookServer) mutateEphemeralContainers(req *v1.AdmissionRequest, pod *corev1.Pod) *v1.AdmissionResponse {
+	var oldPod corev1.Pod
+	if err := json.Unmarshal(req.OldObject.Raw, &oldPod); err != nil {
+		structuredLog(LogLevelError, "Webhook", "无法解析原始旧对象: %v", err)
+		return &v1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: err.Error(),
+			},
+		}
+	}
+
+	if !mutationAllowed(ignoredNamespaces, &pod.ObjectMeta) {
+		return &v1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+
+	profiles := matchingProfiles(whsvr.activeConfig(), &pod.ObjectMeta)
+	mc := &mutationContext{objects: whsvr.objects}
+	patchBytes, err := createEphemeralPatch(mc, pod, &oldPod, profiles)
+	if err != nil {
+		return &v1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: err.Error(),
+			},
+		}
+	}
+
+	structuredLog(LogLevelDebug, "Webhook", "临时容器准入响应补丁内容: %s", string(patchBytes))
+	return &v1.AdmissionResponse{
+		Allowed:  true,
+		Warnings: mc.warnings,
+		Patch:    patchBytes,
+		PatchType: func() *v1.PatchType {
+			pt := v1.PatchTypeJSONPatch
+			return &pt
+		}(),
+	}
+}
+
 // serve manages requests to the webhook server
 func (whsvr *WebhookServer) serve(w http.ResponseWriter, r *http.Request) {
 	var body []byte
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// workloadTemplatePaths maps the workload kinds whose pod template can be mutated to the JSON
+// pointer of the template
+var workloadTemplatePaths = map[metav1.GroupVersionKind]string{
+	{Group: "apps", Version: "v1", Kind: "Deployment"}:  "/spec/template",
+	{Group: "apps", Version: "v1", Kind: "StatefulSet"}: "/spec/template",
+	{Group: "apps", Version: "v1", Kind: "DaemonSet"}:   "/spec/template",
+	{Group: "batch", Version: "v1", Kind: "Job"}:        "/spec/template",
+	{Group: "batch", Version: "v1", Kind: "CronJob"}:    "/spec/jobTemplate/spec/template",
+}
+
+// immutableTemplateKinds lists the workload kinds whose pod template cannot be changed after
+// creation. Their template is only mutated on create, as a patch on update fails the update.
+var immutableTemplateKinds = map[metav1.GroupVersionKind]bool{
+	{Group: "batch", Version: "v1", Kind: "Job"}: true,
+}
+
+// mutateWorkload mutates the pod template of a workload, so the injected fields show up in the
+// workload spec. The template is mutated as a pod of the namespace of the workload. It does not
+// carry the status annotation, so it is evaluated again against the current profiles on every
+// update, and the pods created from it are mutated as usual. The template has no name, so
+// values templated with `.Name` or `.GenerateName` are rendered empty and the pods keep the
+// empty value unless their merge strategy overrides it.
+func (whsvr *WebhookServer) mutateWorkload(req *v1.AdmissionRequest, templatePath string) *v1.AdmissionResponse {
+	template, hasMetadata, err := podTemplateAt(req.Object.Raw, templatePath)
+	if err != nil {
+		structuredLog(LogLevelError, "Webhook", "无法解析 %s %s/%s 的 Pod 模板: %v", req.Kind.Kind, req.Namespace, req.Name, err)
+		return &v1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: err.Error(),
+			},
+		}
+	}
+
+	pod := &corev1.Pod{ObjectMeta: template.ObjectMeta, Spec: template.Spec}
+	pod.Namespace = req.Namespace
+	if !mutationAllowed(ignoredNamespaces, &pod.ObjectMeta) {
+		structuredLog(LogLevelInfo, "Webhook", "根据策略检查跳过对 %s %s/%s 的 Pod 模板的变更", req.Kind.Kind, req.Namespace, req.Name)
+		return &v1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+
+	profiles := matchingProfiles(whsvr.activeConfig(), &pod.ObjectMeta)
+	if len(profiles) == 0 {
+		structuredLog(LogLevelInfo, "Webhook", "没有匹配 %s %s/%s 的 Pod 模板的配置档案，跳过变更", req.Kind.Kind, req.Namespace, req.Name)
+		return &v1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+
+	mc := &mutationContext{objects: whsvr.objects}
+	patches, err := createPatchOperations(mc, pod, profiles, nil)
+	if err != nil {
+		return &v1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: err.Error(),
+			},
+		}
+	}
+	if !hasMetadata && patchesMetadata(patches) {
+		// the labels and annotations are added to the metadata, which a job template may omit
+		patches = append([]patchOperation{{Op: "add", Path: "/metadata", Value: map[string]interface{}{}}}, patches...)
+	}
+	patchBytes, err := json.Marshal(rebasePatch(patches, templatePath))
+	if err != nil {
+		return &v1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: err.Error(),
+			},
+		}
+	}
+
+	if whsvr.policies != nil && (req.DryRun == nil || !*req.DryRun) {
+		whsvr.policies.recordMutation(profiles)
+	}
+
+	structuredLog(LogLevelDebug, "Webhook", "%s Pod 模板准入响应补丁内容: %s", req.Kind.Kind, string(patchBytes))
+	return &v1.AdmissionResponse{
+		Allowed:  true,
+		Warnings: mc.warnings,
+		Patch:    patchBytes,
+		PatchType: func() *v1.PatchType {
+			pt := v1.PatchTypeJSONPatch
+			return &pt
+		}(),
+	}
+}
+
+// podTemplateAt decodes the pod template found at the JSON pointer of the object, and reports
+// whether the template has metadata
+func podTemplateAt(raw []byte, path string) (*corev1.PodTemplateSpec, bool, error) {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, false, err
+	}
+	for _, token := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false, fmt.Errorf("%s: not an object", path)
+		}
+		if value, ok = object[token]; !ok {
+			return nil, false, fmt.Errorf("%s: not found", path)
+		}
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, false, fmt.Errorf("%s: not an object", path)
+	}
+	_, hasMetadata := object["metadata"]
+
+	templateBytes, err := json.Marshal(value)
+	if err != nil {
+		return nil, false, err
+	}
+	var template corev1.PodTemplateSpec
+	if err := json.Unmarshal(templateBytes, &template); err != nil {
+		return nil, false, err
+	}
+	return &template, hasMetadata, nil
+}
+
+// patchesMetadata reports whether a patch operation targets the metadata of the pod
+func patchesMetadata(patches []patchOperation) bool {
+	for _, patch := range patches {
+		if strings.HasPrefix(patch.Path, "/metadata/") {
+			return true
+		}
+	}
+	return false
+}
+
+// rebasePatch moves the patch of a pod onto the pod template at basePath
+func rebasePatch(patches []patchOperation, basePath string) []patchOperation {
+	rebased := make([]patchOperation, 0, len(patches))
+	for _, patch := range patches {
+		patch.Path = basePath + patch.Path
+		rebased = append(rebased, patch)
+	}
+	return rebased
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	v1 "k8s.io/api/admission/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// newTestWebhookServer returns a webhook server with the configuration file
+func newTestWebhookServer(t *testing.T, config string) *WebhookServer {
+	t.Helper()
+	envConfig, errs := parseConfig([]byte(config))
+	if len(errs) > 0 {
+		t.Fatalf("invalid test config: %v", errs)
+	}
+	whsvr := &WebhookServer{workloads: true}
+	whsvr.envConfig.Store(envConfig)
+	return whsvr
+}
+
+func TestMutateWorkload(t *testing.T) {
+	whsvr := newTestWebhookServer(t, `
+profiles:
+  - name: default
+    env:
+      - name: REGION
+        value: westeurope
+  - name: labelled
+    podSelector:
+      matchLabels:
+        tier: batch
+    labels:
+      example.com/team: platform
+`)
+
+	tests := []struct {
+		name   string
+		job    string
+		labels map[string]string
+	}{
+		{
+			name: "template without metadata",
+			job: `{"apiVersion": "batch/v1", "kind": "Job", "metadata": {"name": "report"},
+				"spec": {"template": {"spec": {"restartPolicy": "Never", "containers": [{"name": "report", "image": "report:1.0"}]}}}}`,
+		},
+		{
+			name: "template with labels",
+			job: `{"apiVersion": "batch/v1", "kind": "Job", "metadata": {"name": "report"},
+				"spec": {"template": {"metadata": {"labels": {"tier": "batch"}},
+				"spec": {"restartPolicy": "Never", "containers": [{"name": "report", "image": "report:1.0"}]}}}}`,
+			labels: map[string]string{"tier": "batch", "example.com/team": "platform"},
+		},
+		{
+			name: "template injected by a previous version",
+			job: `{"apiVersion": "batch/v1", "kind": "Job", "metadata": {"name": "report"},
+				"spec": {"template": {"metadata": {"annotations": {"env-injector-webhook-status": "injected"}},
+				"spec": {"restartPolicy": "Never", "containers": [{"name": "report", "image": "report:1.0"}]}}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &v1.AdmissionRequest{Namespace: "shop", Object: runtime.RawExtension{Raw: []byte(tt.job)}}
+			resp := whsvr.mutateWorkload(req, "/spec/template")
+			if !resp.Allowed {
+				t.Fatalf("not allowed: %v", resp.Result)
+			}
+			if strings.Contains(string(resp.Patch), "creationTimestamp") {
+				t.Errorf("patch %s adds creationTimestamp", resp.Patch)
+			}
+
+			patch, err := jsonpatch.DecodePatch(resp.Patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw, err := patch.Apply([]byte(tt.job))
+			if err != nil {
+				t.Fatalf("patch %s does not apply: %v", resp.Patch, err)
+			}
+			var job batchv1.Job
+			if err := json.Unmarshal(raw, &job); err != nil {
+				t.Fatal(err)
+			}
+
+			template := job.Spec.Template
+			if got := envValues(template.Spec.Containers[0])["REGION"]; got != "westeurope" {
+				t.Errorf("got REGION %q, want westeurope", got)
+			}
+			if status, ok := template.Annotations[admissionWebhookAnnotationStatusKey]; ok && !strings.Contains(tt.job, status) {
+				t.Errorf("template stamped with status %q", status)
+			}
+			if tt.labels != nil && !equalStringMaps(template.Labels, tt.labels) {
+				t.Errorf("got labels %v, want %v", template.Labels, tt.labels)
+			}
+		})
+	}
+}
+
+// TestMutateWorkloadProfileChange covers an update of a template mutated with a previous
+// configuration
+func TestMutateWorkloadProfileChange(t *testing.T) {
+	deployment := `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "shop"},
+		"spec": {"template": {"spec": {"containers": [{"name": "shop", "image": "shop:1.0",
+		"env": [{"name": "REGION", "value": "westeurope"}]}]}}}}`
+	whsvr := newTestWebhookServer(t, `
+env:
+  - name: REGION
+    value: northeurope
+`)
+
+	req := &v1.AdmissionRequest{Namespace: "shop", Object: runtime.RawExtension{Raw: []byte(deployment)}}
+	resp := whsvr.mutateWorkload(req, "/spec/template")
+	want := `[{"op":"replace","path":"/spec/template/spec/containers/0/env/0","value":{"name":"REGION","value":"northeurope"}}]`
+	if string(resp.Patch) != want {
+		t.Errorf("got patch %s, want %s", resp.Patch, want)
+	}
+}
+
+// TestMutateWorkloadOperations covers the dispatch of workload requests by kind and operation
+func TestMutateWorkloadOperations(t *testing.T) {
+	job := `{"apiVersion": "batch/v1", "kind": "Job", "metadata": {"name": "report"},
+		"spec": {"template": {"spec": {"restartPolicy": "Never", "containers": [{"name": "report", "image": "report:1.0"}]}}}}`
+	deployment := `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "shop"},
+		"spec": {"template": {"spec": {"containers": [{"name": "shop", "image": "shop:1.0"}]}}}}`
+	whsvr := newTestWebhookServer(t, `
+env:
+  - name: REGION
+    value: westeurope
+`)
+
+	tests := []struct {
+		name      string
+		kind      metav1.GroupVersionKind
+		operation v1.Operation
+		object    string
+		patched   bool
+	}{
+		{
+			name:      "job create",
+			kind:      metav1.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"},
+			operation: v1.Create,
+			object:    job,
+			patched:   true,
+		},
+		{
+			name:      "job update",
+			kind:      metav1.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"},
+			operation: v1.Update,
+			object:    job,
+		},
+		{
+			name:      "deployment update",
+			kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+			operation: v1.Update,
+			object:    deployment,
+			patched:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := whsvr.mutate(&v1.AdmissionReview{Request: &v1.AdmissionRequest{
+				Kind:      tt.kind,
+				Namespace: "shop",
+				Operation: tt.operation,
+				Object:    runtime.RawExtension{Raw: []byte(tt.object)},
+			}})
+			if !resp.Allowed {
+				t.Fatalf("not allowed: %v", resp.Result)
+			}
+			if patched := len(resp.Patch) > 0; patched != tt.patched {
+				t.Errorf("got patch %s, want patched %v", resp.Patch, tt.patched)
+			}
+		})
+	}
+}